@@ -0,0 +1,324 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	// ErrMismatchedBrackets is returned when an address has a missing or stray square bracket.
+	ErrMismatchedBrackets = errors.New("mismatched brackets in address")
+	// ErrMissingPort is returned when an address that requires a port has none.
+	ErrMissingPort = errors.New("missing port in address")
+	// ErrInvalidPort is returned when the port of an address is not a number between 1 and 65535.
+	ErrInvalidPort = errors.New("invalid port in address")
+	// ErrUnusableAddress is returned when an address cannot be used as a destination.
+	ErrUnusableAddress = errors.New("unusable address")
+)
+
+// HasBalancedBrackets returns true if addr either contains no square brackets
+// or a single opening bracket followed by a single closing bracket, as in
+// [::1]:80.
+func HasBalancedBrackets(addr string) bool {
+	opening, closing := strings.Count(addr, "["), strings.Count(addr, "]")
+	if opening == 0 && closing == 0 {
+		return true
+	}
+	return opening == 1 && closing == 1 && strings.Index(addr, "[") < strings.Index(addr, "]")
+}
+
+// SplitHostPort is like net.SplitHostPort, but reports ErrMismatchedBrackets
+// for inputs such as [::1:80 or ::1]:80 rather than a less descriptive error.
+// A bracketed IPv6 address may carry a zone, as in [fe80::1%eth0]:8080, which
+// is returned as part of the host (fe80::1%eth0) so that net.JoinHostPort
+// reproduces the input. An empty zone, as in [fe80::1%]:8080, is rejected.
+func SplitHostPort(addr string) (host, port string, err error) {
+	if !HasBalancedBrackets(addr) {
+		return "", "", fmt.Errorf("address %s: %w", addr, ErrMismatchedBrackets)
+	}
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if i := strings.LastIndexByte(host, '%'); i >= 0 && i == len(host)-1 {
+		return "", "", fmt.Errorf("address %s: empty IPv6 zone", addr)
+	}
+	return host, port, nil
+}
+
+// CanonicalAddr returns the canonical host:port form of addr. IP hosts are
+// normalized (IPv4-mapped IPv6 addresses are unwrapped and IPv6 addresses are
+// compressed and bracketed), while hostnames are lowercased but not resolved.
+// Ports are normalized to their decimal form, e.g. 080 becomes 80. An error is
+// returned if the host or port is missing, or the port is not numeric.
+func CanonicalAddr(addr string) (string, error) {
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", fmt.Errorf("address %s: missing host", addr)
+	}
+	if port == "" {
+		return "", fmt.Errorf("address %s: missing port", addr)
+	}
+	pPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("address %s: invalid port %q", addr, port)
+	}
+	port = strconv.FormatUint(pPort, 10)
+	if ip, err := netip.ParseAddr(host); err == nil {
+		host = ip.Unmap().String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// SameEndpoint reports whether the host:port addresses a and b refer to the
+// same endpoint: their ports must match and their hosts, resolved with lookup
+// if they are hostnames, must share at least one IP address. An error is
+// returned if either address is invalid or cannot be resolved.
+func SameEndpoint(a, b string, lookup LookupIPAddrType) (bool, error) {
+	hostA, portA, err := splitHostPort(a)
+	if err != nil {
+		return false, err
+	}
+	hostB, portB, err := splitHostPort(b)
+	if err != nil {
+		return false, err
+	}
+	addrsA, err := resolveHost(hostA, lookup)
+	if err != nil {
+		return false, err
+	}
+	addrsB, err := resolveHost(hostB, lookup)
+	if err != nil {
+		return false, err
+	}
+	return portA == portB && addrsOverlap(addrsA, addrsB), nil
+}
+
+// SameHost returns true if a and b resolve to at least one common IP
+// address, i.e. they are served by the same host. Ports are ignored, and
+// either side may be given with or without one, e.g. www.foo.com,
+// 1.2.3.4:80 or [2001:db8::1]. Addresses are unmapped before comparison. An
+// error is returned if either side cannot be parsed or resolved.
+func SameHost(a, b string, lookup LookupIPAddrType) (bool, error) {
+	hostA, err := hostOf(a)
+	if err != nil {
+		return false, err
+	}
+	hostB, err := hostOf(b)
+	if err != nil {
+		return false, err
+	}
+	addrsA, err := resolveHost(hostA, lookup)
+	if err != nil {
+		return false, err
+	}
+	addrsB, err := resolveHost(hostB, lookup)
+	if err != nil {
+		return false, err
+	}
+	return addrsOverlap(addrsA, addrsB), nil
+}
+
+// hostOf returns the host part of addr, which may or may not include a port.
+func hostOf(addr string) (string, error) {
+	if addr == "" {
+		return "", ErrResolveNoAddress
+	}
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return addr, nil
+	}
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		return addr[1 : len(addr)-1], nil
+	}
+	if !strings.Contains(addr, ":") {
+		return addr, nil
+	}
+	host, _, err := SplitHostPort(addr)
+	return host, err
+}
+
+// addrsOverlap returns true if a and b have at least one address in common.
+func addrsOverlap(a, b []netip.Addr) bool {
+	set := make(map[netip.Addr]struct{}, len(a))
+	for _, addr := range a {
+		set[addr] = struct{}{}
+	}
+	for _, addr := range b {
+		if _, f := set[addr]; f {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWeightedAddr splits an address with an optional weight annotation, such
+// as 1.2.3.4:80;weight=5, into the address and its weight. The weight defaults
+// to 1 when absent and must otherwise be a positive 32-bit integer.
+func ParseWeightedAddr(s string) (addr string, weight uint32, err error) {
+	addr, annotation, found := strings.Cut(s, ";")
+	if addr == "" {
+		return "", 0, ErrResolveNoAddress
+	}
+	if !found {
+		return addr, 1, nil
+	}
+	const weightPrefix = "weight="
+	if !strings.HasPrefix(annotation, weightPrefix) {
+		return "", 0, fmt.Errorf("address %s: invalid annotation %q, expected weight=<n>", s, annotation)
+	}
+	value := strings.TrimPrefix(annotation, weightPrefix)
+	w, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || w == 0 {
+		return "", 0, fmt.Errorf("address %s: invalid weight %q, must be a positive integer", s, value)
+	}
+	return addr, uint32(w), nil
+}
+
+// IsIPLiteral returns true if host is an IP address rather than a hostname.
+// IPv6 addresses may be enclosed in square brackets and carry a zone, as in
+// [fe80::1%eth0]. Numeric-looking hostnames such as 123 are not IP literals.
+func IsIPLiteral(host string) bool {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		addr, err := netip.ParseAddr(host[1 : len(host)-1])
+		return err == nil && addr.Is6()
+	}
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// CanonicalFQDN returns the canonical form of the DNS name host for
+// comparison and deduplication: lowercased with a single trailing dot
+// removed, so that svc.example.com. and SVC.example.com compare equal. IP
+// literals, whose zones are case-sensitive, are returned untouched.
+func CanonicalFQDN(host string) string {
+	if IsIPLiteral(host) {
+		return host
+	}
+	return normalizeDNSName(host)
+}
+
+// ListenerKey returns a canonical key for the ip:port listener address addr,
+// so that equivalent textual forms such as [::ffff:10.0.0.1]:80 and
+// 10.0.0.1:80 produce the same key. An error is returned if addr is not a
+// valid IP address and port.
+func ListenerKey(addr string) (string, error) {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid listener address %s: %v", addr, err)
+	}
+	return netip.AddrPortFrom(ip.Unmap(), port).String(), nil
+}
+
+// FindDuplicateEndpoints normalizes each address:port in addrs with ListenerKey and
+// returns, in sorted order, the keys that appear more than once. Malformed entries
+// are reported in the returned error; the duplicates among the remaining entries
+// are still returned.
+func FindDuplicateEndpoints(addrs []string) ([]string, error) {
+	var errs *multierror.Error
+	counts := make(map[string]int, len(addrs))
+	for i, addr := range addrs {
+		key, err := ListenerKey(addr)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid endpoint %q at index %d: %v", addr, i, err))
+			continue
+		}
+		counts[key]++
+	}
+	var duplicates []string
+	for key, n := range counts {
+		if n > 1 {
+			duplicates = append(duplicates, key)
+		}
+	}
+	sort.Strings(duplicates)
+	return duplicates, errs.ErrorOrNil()
+}
+
+// ValidEnvoyUpstream returns an error if addr, an ip:port or host:port
+// address, is not something Envoy can dial. Hostnames are allowed since Envoy
+// can resolve them, but unspecified, multicast and limited broadcast IPs are
+// rejected with ErrUnusableAddress. A missing or empty port is reported as
+// ErrMissingPort and a non-numeric or out of range port as ErrInvalidPort. IPv6 addresses must be
+// enclosed in square brackets, and only IPv6 addresses may be.
+func ValidEnvoyUpstream(addr string) error {
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		var addrErr *net.AddrError
+		if errors.As(err, &addrErr) && addrErr.Err == "missing port in address" {
+			return fmt.Errorf("address %s: %w", addr, ErrMissingPort)
+		}
+		return err
+	}
+	if port == "" {
+		return fmt.Errorf("address %s: %w", addr, ErrMissingPort)
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return fmt.Errorf("address %s: %w: %q", addr, ErrInvalidPort, port)
+	}
+	if host == "" {
+		return fmt.Errorf("address %s: %w: empty host", addr, ErrUnusableAddress)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		if strings.HasPrefix(addr, "[") {
+			return fmt.Errorf("address %s: brackets are only allowed around IPv6 addresses", addr)
+		}
+		return nil
+	}
+	if strings.HasPrefix(addr, "[") != ip.Is6() {
+		return fmt.Errorf("address %s: brackets are only allowed around IPv6 addresses", addr)
+	}
+	if isNonUnicast(ip.Unmap()) {
+		return fmt.Errorf("address %s: %w: %s is not a unicast address", addr, ErrUnusableAddress, host)
+	}
+	return nil
+}
+
+// SamePort returns the port shared by every host:port entry of addrs, e.g.
+// to check that an endpoint group can be collapsed into a single listener.
+// The boolean is false if the ports differ, any entry is malformed, or addrs
+// is empty.
+func SamePort(addrs []string) (string, bool) {
+	if len(addrs) == 0 {
+		return "", false
+	}
+	var common uint16
+	for i, addr := range addrs {
+		_, port, err := splitHostPort(addr)
+		if err != nil || (i > 0 && port != common) {
+			return "", false
+		}
+		common = port
+	}
+	return strconv.Itoa(int(common)), true
+}
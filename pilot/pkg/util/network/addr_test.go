@@ -0,0 +1,444 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		addr    string
+		host    string
+		port    string
+		wantErr bool
+	}{
+		{addr: "1.2.3.4:80", host: "1.2.3.4", port: "80"},
+		{addr: "[2001:db8::1]:443", host: "2001:db8::1", port: "443"},
+		{addr: "[fe80::1%eth0]:8080", host: "fe80::1%eth0", port: "8080"},
+		{addr: "[fe80::1%25eth0]:8080", host: "fe80::1%25eth0", port: "8080"},
+		{addr: "[fe80::1%en0.100]:8080", host: "fe80::1%en0.100", port: "8080"},
+		{addr: "www.foo.com:80", host: "www.foo.com", port: "80"},
+		{addr: "[fe80::1%]:8080", wantErr: true},
+		{addr: "fe80::1%eth0:8080", wantErr: true},
+		{addr: "[fe80::1%eth0:8080", wantErr: true},
+		{addr: "[fe80::1%eth0]", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := SplitHostPort(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.addr, tt.wantErr, err)
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("Test %s failed, expected: %s %s got: %s %s", tt.addr, tt.host, tt.port, host, port)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if joined := net.JoinHostPort(host, port); joined != tt.addr {
+			t.Errorf("Test %s failed, expected round trip but got: %s", tt.addr, joined)
+		}
+	}
+}
+
+func TestCanonicalAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		expected string
+		errStr   string
+	}{
+		{
+			name:     "ipv4",
+			addr:     "1.2.3.4:80",
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:     "ipv6 is compressed",
+			addr:     "[2001:0db8:0:0::1]:80",
+			expected: "[2001:db8::1]:80",
+		},
+		{
+			name:     "ipv4-mapped ipv6 is unmapped",
+			addr:     "[::ffff:1.2.3.4]:80",
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:     "hostname is lowercased",
+			addr:     "WWW.Foo.com:80",
+			expected: "www.foo.com:80",
+		},
+		{
+			name:     "port is normalized",
+			addr:     "1.2.3.4:080",
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:   "missing host",
+			addr:   ":80",
+			errStr: "address :80: missing host",
+		},
+		{
+			name:   "missing port",
+			addr:   "1.2.3.4",
+			errStr: "address 1.2.3.4: missing port in address",
+		},
+		{
+			name:   "empty port",
+			addr:   "1.2.3.4:",
+			errStr: "address 1.2.3.4:: missing port",
+		},
+		{
+			name:   "invalid port",
+			addr:   "1.2.3.4:http",
+			errStr: `address 1.2.3.4:http: invalid port "http"`,
+		},
+	}
+	for _, tt := range tests {
+		result, err := CanonicalAddr(tt.addr)
+		if tt.errStr != "" {
+			if err == nil || err.Error() != tt.errStr {
+				t.Errorf("Test %s failed, expected error %q, got: %v", tt.name, tt.errStr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s failed, expected success, got: %v", tt.name, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestSameEndpoint(t *testing.T) {
+	lookup := func(_ context.Context, host string) ([]netip.Addr, error) {
+		switch host {
+		case "www.foo.com":
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:db8::1")}, nil
+		case "alias.foo.com":
+			return []netip.Addr{netip.MustParseAddr("::ffff:1.2.3.4")}, nil
+		case "www.bar.com":
+			return []netip.Addr{netip.MustParseAddr("5.6.7.8")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	tests := []struct {
+		name     string
+		a, b     string
+		expected bool
+		wantErr  bool
+	}{
+		{name: "same ip", a: "1.2.3.4:80", b: "1.2.3.4:80", expected: true},
+		{name: "different port", a: "1.2.3.4:80", b: "1.2.3.4:81", expected: false},
+		{name: "hostname and ip", a: "www.foo.com:80", b: "[2001:db8::1]:80", expected: true},
+		{name: "hostnames sharing an ip", a: "www.foo.com:80", b: "alias.foo.com:80", expected: true},
+		{name: "hostnames not sharing an ip", a: "www.foo.com:80", b: "www.bar.com:80", expected: false},
+		{name: "mapped and plain ip", a: "[::ffff:1.2.3.4]:80", b: "1.2.3.4:80", expected: true},
+		{name: "unresolvable host", a: "www.foo.com:80", b: "www.baz.com:80", wantErr: true},
+		{name: "missing port", a: "www.foo.com", b: "www.foo.com:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := SameEndpoint(tt.a, tt.b, lookup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	lookup := func(_ context.Context, host string) ([]netip.Addr, error) {
+		switch host {
+		case "www.foo.com":
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:db8::1")}, nil
+		case "alias.foo.com":
+			return []netip.Addr{netip.MustParseAddr("::ffff:1.2.3.4")}, nil
+		case "www.bar.com":
+			return []netip.Addr{netip.MustParseAddr("5.6.7.8")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	tests := []struct {
+		name     string
+		a, b     string
+		expected bool
+		wantErr  bool
+	}{
+		{name: "same ip", a: "1.2.3.4:80", b: "1.2.3.4:80", expected: true},
+		{name: "different port", a: "1.2.3.4:80", b: "1.2.3.4:81", expected: true},
+		{name: "without ports", a: "www.foo.com", b: "1.2.3.4", expected: true},
+		{name: "bare and bracketed ipv6", a: "2001:db8::1", b: "[2001:db8::1]", expected: true},
+		{name: "hostname and ip", a: "www.foo.com:80", b: "[2001:db8::1]:443", expected: true},
+		{name: "hostnames sharing an ip", a: "www.foo.com", b: "alias.foo.com:80", expected: true},
+		{name: "hostnames not sharing an ip", a: "www.foo.com:80", b: "www.bar.com:80", expected: false},
+		{name: "mapped and plain ip", a: "::ffff:1.2.3.4", b: "1.2.3.4:80", expected: true},
+		{name: "different ips", a: "1.2.3.4", b: "1.2.3.5", expected: false},
+		{name: "unresolvable host", a: "www.foo.com:80", b: "www.baz.com", wantErr: true},
+		{name: "mismatched brackets", a: "[2001:db8::1:80", b: "1.2.3.4", wantErr: true},
+		{name: "empty", a: "", b: "1.2.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := SameHost(tt.a, tt.b, lookup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestParseWeightedAddr(t *testing.T) {
+	tests := []struct {
+		input   string
+		addr    string
+		weight  uint32
+		wantErr bool
+	}{
+		{input: "1.2.3.4:80", addr: "1.2.3.4:80", weight: 1},
+		{input: "1.2.3.4:80;weight=5", addr: "1.2.3.4:80", weight: 5},
+		{input: "[2001:db8::1]:80;weight=4294967295", addr: "[2001:db8::1]:80", weight: 4294967295},
+		{input: "1.2.3.4:80;weight=0", wantErr: true},
+		{input: "1.2.3.4:80;weight=-1", wantErr: true},
+		{input: "1.2.3.4:80;weight=4294967296", wantErr: true},
+		{input: "1.2.3.4:80;weight=", wantErr: true},
+		{input: "1.2.3.4:80;priority=5", wantErr: true},
+		{input: ";weight=5", wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, weight, err := ParseWeightedAddr(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.input, tt.wantErr, err)
+		}
+		if addr != tt.addr || weight != tt.weight {
+			t.Errorf("Test %s failed, expected: %q %d got: %q %d", tt.input, tt.addr, tt.weight, addr, weight)
+		}
+	}
+}
+
+func TestHasBalancedBrackets(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{addr: "1.2.3.4:80", expected: true},
+		{addr: "[::1]:80", expected: true},
+		{addr: "[::1]", expected: true},
+		{addr: "[::1:80", expected: false},
+		{addr: "::1]:80", expected: false},
+		{addr: "]::1[:80", expected: false},
+		{addr: "[[::1]]:80", expected: false},
+		{addr: "[::1]:80]", expected: false},
+	}
+	for _, tt := range tests {
+		if result := HasBalancedBrackets(tt.addr); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.addr, tt.expected, result)
+		}
+		_, _, err := SplitHostPort(tt.addr)
+		if mismatched := errors.Is(err, ErrMismatchedBrackets); mismatched == tt.expected {
+			t.Errorf("Test %s failed, expected mismatched brackets error: %t got: %v", tt.addr, !tt.expected, err)
+		}
+	}
+}
+
+func TestIsIPLiteral(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{host: "1.2.3.4", expected: true},
+		{host: "2001:db8::1", expected: true},
+		{host: "[2001:db8::1]", expected: true},
+		{host: "fe80::1%eth0", expected: true},
+		{host: "[fe80::1%eth0]", expected: true},
+		{host: "::ffff:1.2.3.4", expected: true},
+		{host: "[1.2.3.4]", expected: false},
+		{host: "[2001:db8::1", expected: false},
+		{host: "123", expected: false},
+		{host: "1.2.3", expected: false},
+		{host: "0x7f000001", expected: false},
+		{host: "www.foo.com", expected: false},
+		{host: "localhost", expected: false},
+		{host: "", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsIPLiteral(tt.host); result != tt.expected {
+			t.Errorf("Test %q failed, expected: %t got: %t", tt.host, tt.expected, result)
+		}
+	}
+}
+
+func TestCanonicalFQDN(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{host: "svc.example.com", expected: "svc.example.com"},
+		{host: "svc.example.com.", expected: "svc.example.com"},
+		{host: "SVC.Example.COM.", expected: "svc.example.com"},
+		{host: "svc..", expected: "svc."},
+		{host: ".", expected: ""},
+		{host: "1.2.3.4", expected: "1.2.3.4"},
+		{host: "2001:DB8::1", expected: "2001:DB8::1"},
+		{host: "fe80::1%ETH0", expected: "fe80::1%ETH0"},
+		{host: "[FE80::1%ETH0]", expected: "[FE80::1%ETH0]"},
+		{host: "", expected: ""},
+	}
+	for _, tt := range tests {
+		if result := CanonicalFQDN(tt.host); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %q got: %q", tt.host, tt.expected, result)
+		}
+	}
+}
+
+func TestListenerKey(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+		wantErr  bool
+	}{
+		{addr: "10.0.0.1:80", expected: "10.0.0.1:80"},
+		{addr: "[::ffff:10.0.0.1]:80", expected: "10.0.0.1:80"},
+		{addr: "[2001:0db8:0000::1]:80", expected: "[2001:db8::1]:80"},
+		{addr: "[fe80::1%eth0]:80", expected: "[fe80::1%eth0]:80"},
+		{addr: "10.0.0.1:080", expected: "10.0.0.1:80"},
+		{addr: "www.foo.com:80", wantErr: true},
+		{addr: "10.0.0.1", wantErr: true},
+		{addr: "10.0.0.1:http", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := ListenerKey(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.addr, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.addr, tt.expected, result)
+		}
+	}
+}
+
+func TestFindDuplicateEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "no duplicates",
+			addrs:    []string{"10.0.0.1:80", "10.0.0.1:81", "[::1]:80"},
+			expected: nil,
+		},
+		{
+			name:     "equivalent spellings collide",
+			addrs:    []string{"[::ffff:10.0.0.1]:80", "10.0.0.1:80", "[2001:db8::1]:443", "[2001:0db8::0001]:443", "10.0.0.1:80"},
+			expected: []string{"10.0.0.1:80", "[2001:db8::1]:443"},
+		},
+		{
+			name:     "malformed entries are reported",
+			addrs:    []string{"10.0.0.1:80", "foo.com:80", "10.0.0.1:80", "10.0.0.2"},
+			expected: []string{"10.0.0.1:80"},
+			wantErr:  true,
+		},
+		{
+			name:     "test for empty value",
+			addrs:    nil,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		result, err := FindDuplicateEndpoints(tt.addrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestValidEnvoyUpstream(t *testing.T) {
+	tests := []struct {
+		addr    string
+		err     error
+		wantErr bool
+	}{
+		{addr: "10.0.0.1:80"},
+		{addr: "[2001:db8::1]:443"},
+		{addr: "[::ffff:10.0.0.1]:443"},
+		{addr: "[fe80::1%eth0]:8080"},
+		{addr: "127.0.0.1:15000"},
+		{addr: "www.foo.com:80"},
+		{addr: "10.0.0.1", err: ErrMissingPort},
+		{addr: "www.foo.com", err: ErrMissingPort},
+		{addr: "[2001:db8::1]", err: ErrMissingPort},
+		{addr: "10.0.0.1:http", err: ErrInvalidPort},
+		{addr: "10.0.0.1:0", err: ErrInvalidPort},
+		{addr: "10.0.0.1:65536", err: ErrInvalidPort},
+		{addr: "www.foo.com:", err: ErrMissingPort},
+		{addr: "1.2.3.4:", err: ErrMissingPort},
+		{addr: "0.0.0.0:80", err: ErrUnusableAddress},
+		{addr: "[::]:80", err: ErrUnusableAddress},
+		{addr: "224.0.0.1:80", err: ErrUnusableAddress},
+		{addr: "[ff02::1]:80", err: ErrUnusableAddress},
+		{addr: "[::ffff:0.0.0.0]:80", err: ErrUnusableAddress},
+		{addr: "255.255.255.255:80", err: ErrUnusableAddress},
+		{addr: "[::ffff:255.255.255.255]:80", err: ErrUnusableAddress},
+		{addr: ":80", err: ErrUnusableAddress},
+		{addr: "[2001:db8::1:80", err: ErrMismatchedBrackets},
+		{addr: "2001:db8::1:80", wantErr: true},
+		{addr: "[10.0.0.1]:80", wantErr: true},
+		{addr: "[www.foo.com]:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := ValidEnvoyUpstream(tt.addr)
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("Test %s failed, expected error: %v got: %v", tt.addr, tt.err, err)
+		}
+		if tt.err == nil && (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.addr, tt.wantErr, err)
+		}
+	}
+}
+
+func TestSamePort(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected string
+		ok       bool
+	}{
+		{name: "same port", addrs: []string{"10.0.0.1:80", "[2001:db8::1]:80", "www.foo.com:080"}, expected: "80", ok: true},
+		{name: "single entry", addrs: []string{"10.0.0.1:15001"}, expected: "15001", ok: true},
+		{name: "different ports", addrs: []string{"10.0.0.1:80", "10.0.0.2:81"}, ok: false},
+		{name: "malformed entry", addrs: []string{"10.0.0.1:80", "10.0.0.2"}, ok: false},
+		{name: "invalid port", addrs: []string{"10.0.0.1:http"}, ok: false},
+		{name: "test for empty value", addrs: nil, ok: false},
+	}
+	for _, tt := range tests {
+		result, ok := SamePort(tt.addrs)
+		if result != tt.expected || ok != tt.ok {
+			t.Errorf("Test %s failed, expected: %v %t got: %v %t", tt.name, tt.expected, tt.ok, result, ok)
+		}
+	}
+}
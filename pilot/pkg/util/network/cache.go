@@ -0,0 +1,188 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"istio.io/pkg/log"
+)
+
+// Cache stores resolved addresses per host. Entries are considered fresh for
+// the configured TTL after insertion. It is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	addrs    []netip.Addr
+	inserted time.Time
+}
+
+// NewCache creates an empty Cache whose entries are fresh for ttl.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]cacheEntry{},
+	}
+}
+
+// Get returns the cached addresses for host if a fresh entry exists.
+func (c *Cache) Get(host string) ([]netip.Addr, bool) {
+	e, ok := c.getFresh(host)
+	return e.addrs, ok
+}
+
+// Set stores addrs as the resolved addresses for host.
+func (c *Cache) Set(host string, addrs []netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[host] = cacheEntry{addrs: addrs, inserted: c.now()}
+}
+
+// getFresh returns the entry for host if it is still fresh.
+func (c *Cache) getFresh(host string) (cacheEntry, bool) {
+	e, ok := c.get(host)
+	if !ok || c.now().Sub(e.inserted) >= c.ttl {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// get returns the entry for host, regardless of whether it is still fresh.
+func (c *Cache) get(host string) (cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[host]
+	return e, ok
+}
+
+// NewCachingLookup wraps delegate so that fresh results in cache are returned
+// without calling delegate, and successful results from delegate are stored
+// in cache.
+func NewCachingLookup(delegate LookupIPAddrType, cache *Cache) LookupIPAddrType {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		if e, ok := cache.getFresh(host); ok {
+			setResolvedAt(ctx, e.inserted)
+			return e.addrs, nil
+		}
+		addrs, err := delegate(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		cache.Set(host, addrs)
+		if e, ok := cache.get(host); ok {
+			setResolvedAt(ctx, e.inserted)
+		}
+		return addrs, nil
+	}
+}
+
+// NewStaleOnErrorLookup wraps delegate so that successful results are stored
+// in cache, and when delegate fails the last successful result for the host
+// is returned instead, even if it has expired. delegate is always consulted
+// first, so stale data is only served on error; each time it is, a warning is
+// logged. If there is no cached result the error from delegate is returned.
+func NewStaleOnErrorLookup(delegate LookupIPAddrType, cache *Cache) LookupIPAddrType {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		if err == nil {
+			cache.Set(host, addrs)
+			return addrs, nil
+		}
+		e, ok := cache.get(host)
+		if !ok {
+			return nil, err
+		}
+		log.Warnf("lookup of %s failed, serving stale result from %v: %v", host, e.inserted, err)
+		setResolvedAt(ctx, e.inserted)
+		return e.addrs, nil
+	}
+}
+
+// WarmCache resolves hosts concurrently with lookup and stores the results in
+// cache, so that the first real request for each host is served from cache.
+// A failure for one host does not stop the others; the per-host errors are
+// returned together. WarmCache returns once every lookup finished or ctx is
+// done, whichever comes first; lookups still in flight at that point may keep
+// seeding the cache when they complete.
+func WarmCache(ctx context.Context, cache *Cache, hosts []string, lookup LookupIPAddrType) error {
+	var (
+		mu   sync.Mutex
+		merr *multierror.Error
+		wg   sync.WaitGroup
+	)
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			addrs, err := lookupHost(ctx, host, lookup)
+			if err != nil {
+				mu.Lock()
+				merr = multierror.Append(merr, fmt.Errorf("lookup of %s failed: %w", host, err))
+				mu.Unlock()
+				return
+			}
+			cache.Set(host, addrs)
+		}(host)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		mu.Lock()
+		merr = multierror.Append(merr, fmt.Errorf("cache warm-up interrupted: %w", ctx.Err()))
+		mu.Unlock()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if merr == nil {
+		return nil
+	}
+	// Lookups still in flight keep appending to merr, so return a copy.
+	return &multierror.Error{Errors: append([]error(nil), merr.Errors...)}
+}
+
+type resolvedAtKey struct{}
+
+// withResolvedAt returns a context in which cache-backed lookups record when
+// their result was obtained, and the location the time is recorded to.
+func withResolvedAt(ctx context.Context) (context.Context, *time.Time) {
+	t := new(time.Time)
+	return context.WithValue(ctx, resolvedAtKey{}, t), t
+}
+
+// setResolvedAt records t as the time the result was obtained, if requested by ctx.
+func setResolvedAt(ctx context.Context, t time.Time) {
+	if p, ok := ctx.Value(resolvedAtKey{}).(*time.Time); ok {
+		*p = t
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// fakeClock is a manually advanced clock for Cache tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.t
+}
+
+func newTestCache(ttl time.Duration) (*Cache, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	c := NewCache(ttl)
+	c.now = clock.Now
+	return c, clock
+}
+
+func TestCachingLookup(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	calls := 0
+	lookup := NewCachingLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		calls++
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}, cache)
+
+	for i := 0; i < 3; i++ {
+		if _, err := lookup(context.Background(), "www.foo.com"); err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 delegate call while cached, got %d", calls)
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if _, err := lookup(context.Background(), "www.foo.com"); err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected expired entry to be re-resolved, got %d delegate calls", calls)
+	}
+}
+
+func TestResolveAddrCachedOnly(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	cache.Set("www.foo.com", []netip.Addr{netip.MustParseAddr("2001:db8::68"), netip.MustParseAddr("1.2.3.4")})
+
+	tests := []struct {
+		name     string
+		addr     string
+		expected string
+		hit      bool
+	}{
+		{
+			name:     "cached host",
+			addr:     "www.foo.com:9080",
+			expected: "1.2.3.4:9080",
+			hit:      true,
+		},
+		{
+			name:     "ip literal",
+			addr:     "[::1]:9080",
+			expected: "[::1]:9080",
+			hit:      true,
+		},
+		{
+			name: "uncached host",
+			addr: "www.bar.com:9080",
+		},
+		{
+			name: "missing port",
+			addr: "www.foo.com",
+		},
+	}
+	for _, tt := range tests {
+		result, hit := ResolveAddrCachedOnly(tt.addr, cache)
+		if result != tt.expected || hit != tt.hit {
+			t.Errorf("Test %s failed, expected: %q, %t got: %q, %t", tt.name, tt.expected, tt.hit, result, hit)
+		}
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if result, hit := ResolveAddrCachedOnly("www.foo.com:9080", cache); hit {
+		t.Errorf("expected expired entry to miss, got %q", result)
+	}
+
+	if result, hit := ResolveAddrCachedOnly("www.foo.com:9080", nil); hit || result != "" {
+		t.Errorf("expected nil cache to miss, got %q, %t", result, hit)
+	}
+	if result, hit := ResolveAddrCachedOnly("1.2.3.4:9080", nil); !hit || result != "1.2.3.4:9080" {
+		t.Errorf("expected ip literal to resolve without a cache, got %q, %t", result, hit)
+	}
+}
+
+func TestResolveAddrStamped(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	lookup := NewCachingLookup(staticLookup("2001:db8::68", "1.2.3.4"), cache)
+
+	clock.t = clock.t.Add(30 * time.Second)
+	if _, _, err := ResolveAddrStamped(context.Background(), "www.foo.com:9080", lookup); err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	inserted := clock.t
+
+	clock.t = clock.t.Add(10 * time.Second)
+	addr, at, err := ResolveAddrStamped(context.Background(), "www.foo.com:9080", lookup)
+	if err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if addr.String() != "1.2.3.4:9080" {
+		t.Errorf("expected address %q, got %q", "1.2.3.4:9080", addr)
+	}
+	if !at.Equal(inserted) {
+		t.Errorf("expected cache insertion time %v, got %v", inserted, at)
+	}
+
+	// Without a cache the time of the lookup is reported.
+	before := time.Now()
+	_, at, err = ResolveAddrStamped(context.Background(), "www.foo.com:9080", staticLookup("1.2.3.4"))
+	if err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if at.Before(before) || at.After(time.Now()) {
+		t.Errorf("expected lookup time between %v and now, got %v", before, at)
+	}
+
+	if _, _, err := ResolveAddrStamped(context.Background(), "", lookup); err != ErrResolveNoAddress {
+		t.Errorf("expected %v, got %v", ErrResolveNoAddress, err)
+	}
+}
+
+func TestStaleOnErrorLookup(t *testing.T) {
+	cache, clock := newTestCache(time.Minute)
+	var result []netip.Addr
+	var lookupErr error
+	calls := 0
+	lookup := NewStaleOnErrorLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		calls++
+		return result, lookupErr
+	}, cache)
+
+	lookupErr = fmt.Errorf("server failure")
+	if _, err := lookup(context.Background(), "www.foo.com"); err != lookupErr {
+		t.Errorf("expected delegate error without a cached result, got %v", err)
+	}
+
+	result, lookupErr = []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	if _, err := lookup(context.Background(), "www.foo.com"); err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+
+	// Fresh results are always taken from the delegate.
+	result = []netip.Addr{netip.MustParseAddr("1.2.3.5")}
+	addrs, err := lookup(context.Background(), "www.foo.com")
+	if err != nil || len(addrs) != 1 || addrs[0].String() != "1.2.3.5" {
+		t.Errorf("expected fresh result 1.2.3.5, got %v %v", addrs, err)
+	}
+
+	clock.t = clock.t.Add(time.Hour)
+	result, lookupErr = nil, fmt.Errorf("server failure")
+	addrs, err = lookup(context.Background(), "www.foo.com")
+	if err != nil || len(addrs) != 1 || addrs[0].String() != "1.2.3.5" {
+		t.Errorf("expected stale result 1.2.3.5, got %v %v", addrs, err)
+	}
+	if calls != 4 {
+		t.Errorf("expected the delegate to be called on every lookup, got %d calls", calls)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	cache, _ := newTestCache(time.Minute)
+	lookup := func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host == "bad.example.com" {
+			return nil, fmt.Errorf("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	err := WarmCache(context.Background(), cache, []string{"a.example.com", "bad.example.com", "b.example.com"}, lookup)
+	if err == nil || !strings.Contains(err.Error(), "bad.example.com") {
+		t.Errorf("expected error naming bad.example.com, got %v", err)
+	}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		if _, ok := cache.Get(host); !ok {
+			t.Errorf("expected %s to be cached", host)
+		}
+	}
+	if _, ok := cache.Get("bad.example.com"); ok {
+		t.Errorf("expected failed host not to be cached")
+	}
+
+	if err := WarmCache(context.Background(), cache, nil, lookup); err != nil {
+		t.Errorf("expected no error for empty host list, got %v", err)
+	}
+}
+
+func TestWarmCacheContext(t *testing.T) {
+	cache, _ := newTestCache(time.Minute)
+	release := make(chan struct{})
+	defer close(release)
+	lookup := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		<-release
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := WarmCache(ctx, cache, []string{"slow.example.com"}, lookup)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+}
+
+func TestWarmCacheLateFailure(t *testing.T) {
+	cache, _ := newTestCache(time.Minute)
+	release, failed := make(chan struct{}), make(chan struct{})
+	lookup := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		<-release
+		defer close(failed)
+		return nil, fmt.Errorf("no such host")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := WarmCache(ctx, cache, []string{"slow.example.com"}, lookup)
+	merr, ok := err.(*multierror.Error)
+	if !ok || len(merr.Errors) != 1 {
+		t.Fatalf("expected only the deadline error, got %v", err)
+	}
+	close(release)
+	<-failed
+	// The failure arrives after WarmCache returned and must not change its
+	// result; run with -race to catch concurrent appends.
+	deadline := time.Now().Add(20 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if msg := err.Error(); strings.Contains(msg, "slow.example.com") {
+			t.Fatalf("expected late failure not to be reported, got %v", msg)
+		}
+	}
+}
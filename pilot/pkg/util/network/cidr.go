@@ -0,0 +1,465 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// SubtractCIDR returns the smallest set of prefixes covering base but not
+// exclude, sorted by address. For example 10.0.0.0/24 minus 10.0.0.0/25
+// yields 10.0.0.128/25. Subtracting a prefix from itself yields an empty set.
+// An error is returned if either prefix is invalid, the families differ, or
+// exclude is not contained in base.
+func SubtractCIDR(base, exclude string) ([]string, error) {
+	b, err := netip.ParsePrefix(base)
+	if err != nil {
+		return nil, err
+	}
+	e, err := netip.ParsePrefix(exclude)
+	if err != nil {
+		return nil, err
+	}
+	b, e = b.Masked(), e.Masked()
+	if b.Addr().Is4() != e.Addr().Is4() {
+		return nil, fmt.Errorf("cannot subtract %s from %s: IP families differ", exclude, base)
+	}
+	if e.Bits() < b.Bits() || !b.Contains(e.Addr()) {
+		return nil, fmt.Errorf("cannot subtract %s from %s: not contained in base", exclude, base)
+	}
+
+	// Walk down from base towards exclude; at each level the sibling of the
+	// half containing exclude is entirely outside of it.
+	prefixes := make([]netip.Prefix, 0, e.Bits()-b.Bits())
+	for bits := b.Bits(); bits < e.Bits(); bits++ {
+		half := netip.PrefixFrom(e.Addr(), bits+1).Masked()
+		prefixes = append(prefixes, netip.PrefixFrom(flipBit(half.Addr(), bits), bits+1))
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out, nil
+}
+
+// flipBit returns addr with the given bit, counted from the most significant bit, inverted.
+func flipBit(addr netip.Addr, bit int) netip.Addr {
+	b := addr.AsSlice()
+	b[bit/8] ^= 0x80 >> (bit % 8)
+	ret, _ := netip.AddrFromSlice(b)
+	return ret
+}
+
+// EnvoyCIDR holds the components of an Envoy CidrRange.
+type EnvoyCIDR struct {
+	AddressPrefix string
+	PrefixLen     uint32
+}
+
+// ToEnvoyCIDRs parses each of cidrs into its Envoy CidrRange components. All
+// invalid prefixes are reported in the returned error.
+func ToEnvoyCIDRs(cidrs []string) ([]EnvoyCIDR, error) {
+	var errs *multierror.Error
+	out := make([]EnvoyCIDR, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		out = append(out, EnvoyCIDR{
+			AddressPrefix: p.Addr().String(),
+			PrefixLen:     uint32(p.Bits()),
+		})
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HostPrefix returns the single-host prefix for ip: a /32 for IPv4 and a
+// /128 for IPv6. Any IPv6 zone is dropped.
+func HostPrefix(ip string) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ValidateCIDRs parses every prefix in cidrs, returning an error naming each
+// invalid prefix and its index. An empty list is valid.
+func ValidateCIDRs(cidrs []string) error {
+	var errs *multierror.Error
+	for i, cidr := range cidrs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid CIDR %q at index %d: %v", cidr, i, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// RangeIter returns a pull-style iterator over every address in cidr, in
+// ascending order, without materializing the range. Each call returns the next
+// address and true, or false once the range is exhausted.
+func RangeIter(cidr string) (func() (netip.Addr, bool), error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	p = p.Masked()
+	cur := p.Addr()
+	return func() (netip.Addr, bool) {
+		// Next returns the zero Addr after the last address of the family.
+		if !cur.IsValid() || !p.Contains(cur) {
+			return netip.Addr{}, false
+		}
+		ret := cur
+		cur = cur.Next()
+		return ret, true
+	}, nil
+}
+
+// MatchingCIDR returns the most specific (longest prefix) entry of cidrs that
+// contains ip; the first such entry wins ties. IPv4-mapped IPv6 addresses and
+// prefixes are matched in their IPv4 form, and any zone of ip is ignored. The
+// boolean reports whether any entry matched. An error is returned if ip or any of cidrs is malformed.
+func MatchingCIDR(ip string, cidrs []string) (string, bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false, err
+	}
+	addr = addr.Unmap().WithZone("")
+	best, bestBits := "", -1
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return "", false, err
+		}
+		p = unmapPrefix(p)
+		if p.Bits() > bestBits && p.Contains(addr) {
+			best, bestBits = cidr, p.Bits()
+		}
+	}
+	return best, bestBits >= 0, nil
+}
+
+// unmapPrefix converts an IPv4-mapped IPv6 prefix, such as ::ffff:10.0.0.0/104,
+// to its IPv4 form. Other prefixes are returned unchanged.
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p
+}
+
+// IPInAnyCIDR returns true if ip is contained in any of cidrs. An error is
+// returned if ip or any of cidrs is malformed.
+func IPInAnyCIDR(ip string, cidrs []string) (bool, error) {
+	_, matched, err := MatchingCIDR(ip, cidrs)
+	return matched, err
+}
+
+// InPodCIDR returns true if ip falls within one of the node's pod CIDRs,
+// flagging endpoints that claim addresses outside the expected range. An
+// error is returned if ip or any of podCIDRs is malformed.
+func InPodCIDR(ip string, podCIDRs []string) (bool, error) {
+	return IPInAnyCIDR(ip, podCIDRs)
+}
+
+// PrefixToNetmask returns the dotted-decimal netmask for an IPv4 prefix, for
+// example 255.255.255.0 for 10.0.0.0/24. IPv6 prefixes have no dotted
+// netmask form and are rejected.
+func PrefixToNetmask(cidr string) (string, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	p = unmapPrefix(p)
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("netmask notation is only supported for IPv4 prefixes: %s", cidr)
+	}
+	var mask [4]byte
+	binary.BigEndian.PutUint32(mask[:], ^uint32(0)<<(32-p.Bits()))
+	return netip.AddrFrom4(mask).String(), nil
+}
+
+// NetmaskToPrefix is the inverse of PrefixToNetmask: it combines an IPv4
+// address and a dotted-decimal netmask into CIDR notation, for example
+// 10.0.0.1 and 255.255.255.0 yield 10.0.0.1/24. The address is not masked.
+// An error is returned for IPv6 addresses and for netmasks whose set bits are
+// not contiguous.
+func NetmaskToPrefix(ip, mask string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return "", fmt.Errorf("netmask notation is only supported for IPv4 addresses: %s", ip)
+	}
+	m, err := netip.ParseAddr(mask)
+	if err != nil || !m.Is4() {
+		return "", fmt.Errorf("invalid netmask %q", mask)
+	}
+	b := m.As4()
+	v := binary.BigEndian.Uint32(b[:])
+	// A contiguous mask inverted is of the form 0...01...1, so adding one
+	// leaves no bits in common with it.
+	if inv := ^v; inv&(inv+1) != 0 {
+		return "", fmt.Errorf("invalid netmask %q: bits are not contiguous", mask)
+	}
+	return netip.PrefixFrom(addr, bits.OnesCount32(v)).String(), nil
+}
+
+// GroupBySubnet buckets addrs by the subnet containing them, keyed by the
+// masked prefix, e.g. 10.0.1.0/24. IPv4 addresses are grouped by subnets of
+// length v4PrefixLen, which must be between 0 and 32, and IPv6 addresses by
+// subnets of length v6PrefixLen, which must be between 0 and 128. IPv4-mapped
+// IPv6 addresses are grouped as IPv4. Addresses keep their order within a
+// bucket.
+func GroupBySubnet(addrs []string, v4PrefixLen, v6PrefixLen int) (map[string][]string, error) {
+	if v4PrefixLen < 0 || v4PrefixLen > 32 {
+		return nil, fmt.Errorf("invalid IPv4 prefix length %d", v4PrefixLen)
+	}
+	if v6PrefixLen < 0 || v6PrefixLen > 128 {
+		return nil, fmt.Errorf("invalid IPv6 prefix length %d", v6PrefixLen)
+	}
+	groups := map[string][]string{}
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap().WithZone("")
+		prefixLen := v6PrefixLen
+		if addr.Is4() {
+			prefixLen = v4PrefixLen
+		}
+		p, err := addr.Prefix(prefixLen)
+		if err != nil {
+			return nil, err
+		}
+		groups[p.String()] = append(groups[p.String()], a)
+	}
+	return groups, nil
+}
+
+// ParseCIDRList parses a list of prefixes separated by commas and/or
+// whitespace, as commonly stored in a single config field, e.g.
+// "10.0.0.0/8, 2001:db8::/32". Empty entries are ignored and the order is
+// preserved. If any entry is invalid, an error naming every invalid entry is
+// returned.
+func ParseCIDRList(s string) ([]netip.Prefix, error) {
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	var errs *multierror.Error
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid CIDR %q: %v", entry, err))
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// FirstUsableAddr returns the first assignable host address of cidr, which
+// many deployments use as the gateway: the address following the network
+// address for IPv4 prefixes shorter than /31, and the network address itself
+// for /31 and /32 IPv4 prefixes (RFC 3021) and all IPv6 prefixes, which have
+// no broadcast semantics.
+func FirstUsableAddr(cidr string) (string, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	p = unmapPrefix(p).Masked()
+	addr := p.Addr()
+	if addr.Is4() && p.Bits() < 31 {
+		addr = addr.Next()
+	}
+	return addr.String(), nil
+}
+
+// CanonicalCIDR returns cidr with its host bits masked off, e.g. 10.0.0.0/24
+// for 10.0.0.5/24, so that it describes the network users usually intend. An
+// error is returned if cidr is malformed.
+func CanonicalCIDR(cidr string) (string, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	return p.Masked().String(), nil
+}
+
+// NetworkMatcher finds the mesh network an address belongs to, given the
+// CIDRs of each network. It is safe for concurrent use.
+type NetworkMatcher struct {
+	prefixes []networkPrefix
+}
+
+type networkPrefix struct {
+	network string
+	prefix  netip.Prefix
+}
+
+// NewNetworkMatcher precompiles networks, a map of network name to its CIDRs,
+// for repeated matching. An error naming every malformed CIDR is returned.
+func NewNetworkMatcher(networks map[string][]string) (*NetworkMatcher, error) {
+	m, err := compileNetworks(networks)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// compileNetworks returns a matcher for the valid CIDRs of networks, along
+// with an error for the malformed ones. Prefixes are ordered from longest
+// to shortest, ties going to the network whose name sorts first.
+func compileNetworks(networks map[string][]string) (*NetworkMatcher, error) {
+	var errs *multierror.Error
+	m := &NetworkMatcher{}
+	for network, cidrs := range networks {
+		for _, cidr := range cidrs {
+			p, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("invalid CIDR %q for network %s: %v", cidr, network, err))
+				continue
+			}
+			m.prefixes = append(m.prefixes, networkPrefix{network: network, prefix: unmapPrefix(p).Masked()})
+		}
+	}
+	sort.Slice(m.prefixes, func(i, j int) bool {
+		a, b := m.prefixes[i], m.prefixes[j]
+		if a.prefix.Bits() != b.prefix.Bits() {
+			return a.prefix.Bits() > b.prefix.Bits()
+		}
+		return a.network < b.network
+	})
+	return m, errs.ErrorOrNil()
+}
+
+// Network returns the network with the most specific CIDR containing ip.
+// The boolean is false if no network matches or ip is invalid.
+func (m *NetworkMatcher) Network(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, np := range m.prefixes {
+		if np.prefix.Contains(addr) {
+			return np.network, true
+		}
+	}
+	return "", false
+}
+
+// NetworkForAddress returns the mesh network ip belongs to, given networks, a
+// map of network name to its CIDRs. The network with the most specific CIDR
+// containing ip wins, regardless of which network it belongs to; ties go to
+// the network whose name sorts first. Malformed CIDRs are ignored. The boolean
+// is false if no network matches. Callers matching many addresses against the
+// same networks should use a NetworkMatcher instead.
+func NetworkForAddress(ip string, networks map[string][]string) (string, bool) {
+	m, _ := compileNetworks(networks)
+	return m.Network(ip)
+}
+
+// CIDRListDiff returns the prefixes present in newCIDRs but not oldCIDRs
+// (added) and those present in oldCIDRs but not newCIDRs (removed), e.g. to
+// drive incremental route or firewall updates. Prefixes are compared in their
+// canonical form, so 10.0.0.5/24 and 10.0.0.0/24 are equal, and are returned
+// canonicalized and sorted by address and then length. An error naming every
+// malformed prefix is returned.
+func CIDRListDiff(oldCIDRs, newCIDRs []string) (added, removed []string, err error) {
+	var errs *multierror.Error
+	canonicalSet := func(cidrs []string) map[netip.Prefix]struct{} {
+		set := make(map[netip.Prefix]struct{}, len(cidrs))
+		for _, cidr := range cidrs {
+			p, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("invalid CIDR %q: %v", cidr, err))
+				continue
+			}
+			set[p.Masked()] = struct{}{}
+		}
+		return set
+	}
+	oldSet, newSet := canonicalSet(oldCIDRs), canonicalSet(newCIDRs)
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, nil, err
+	}
+	return prefixSetDifference(newSet, oldSet), prefixSetDifference(oldSet, newSet), nil
+}
+
+// prefixSetDifference returns the sorted prefixes of a that are not in b.
+func prefixSetDifference(a, b map[netip.Prefix]struct{}) []string {
+	var prefixes []netip.Prefix
+	for p := range a {
+		if _, f := b[p]; !f {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Addr() != prefixes[j].Addr() {
+			return prefixes[i].Addr().Less(prefixes[j].Addr())
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+	var out []string
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+// FirstAddrNotIn returns the first valid address of addrs that is not in any
+// of the deny CIDRs, e.g. to advertise any address except those of the
+// management subnet. ErrResolveNoAddress is returned if every address is
+// denied or invalid, and an error if any of deny is malformed.
+func FirstAddrNotIn(addrs []string, deny []string) (string, error) {
+	if err := ValidateCIDRs(deny); err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		denied, err := IPInAnyCIDR(addr, deny)
+		if err == nil && !denied {
+			return addr, nil
+		}
+	}
+	return "", ErrResolveNoAddress
+}
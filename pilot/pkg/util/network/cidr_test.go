@@ -0,0 +1,730 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubtractCIDR(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		exclude  string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "lower half",
+			base:     "10.0.0.0/24",
+			exclude:  "10.0.0.0/25",
+			expected: []string{"10.0.0.128/25"},
+		},
+		{
+			name:     "upper half",
+			base:     "10.0.0.0/24",
+			exclude:  "10.0.0.128/25",
+			expected: []string{"10.0.0.0/25"},
+		},
+		{
+			name:     "single host in the middle",
+			base:     "10.0.0.0/29",
+			exclude:  "10.0.0.5/32",
+			expected: []string{"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31"},
+		},
+		{
+			name:     "host bits in input are masked",
+			base:     "10.0.0.7/24",
+			exclude:  "10.0.0.200/25",
+			expected: []string{"10.0.0.0/25"},
+		},
+		{
+			name:     "equal prefixes",
+			base:     "10.0.0.0/24",
+			exclude:  "10.0.0.0/24",
+			expected: []string{},
+		},
+		{
+			name:     "ipv6",
+			base:     "2001:db8::/32",
+			exclude:  "2001:db8::/34",
+			expected: []string{"2001:db8:4000::/34", "2001:db8:8000::/33"},
+		},
+		{
+			name:    "exclude not contained",
+			base:    "10.0.0.0/24",
+			exclude: "10.0.1.0/25",
+			wantErr: true,
+		},
+		{
+			name:    "exclude larger than base",
+			base:    "10.0.0.0/25",
+			exclude: "10.0.0.0/24",
+			wantErr: true,
+		},
+		{
+			name:    "families differ",
+			base:    "10.0.0.0/24",
+			exclude: "2001:db8::/64",
+			wantErr: true,
+		},
+		{
+			name:    "invalid base",
+			base:    "10.0.0.0/33",
+			exclude: "10.0.0.0/25",
+			wantErr: true,
+		},
+		{
+			name:    "invalid exclude",
+			base:    "10.0.0.0/24",
+			exclude: "invalid",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := SubtractCIDR(tt.base, tt.exclude)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestToEnvoyCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		expected []EnvoyCIDR
+		errStrs  []string
+	}{
+		{
+			name:  "valid prefixes",
+			cidrs: []string{"10.0.0.0/8", "2001:db8::/32", "1.2.3.4/32"},
+			expected: []EnvoyCIDR{
+				{AddressPrefix: "10.0.0.0", PrefixLen: 8},
+				{AddressPrefix: "2001:db8::", PrefixLen: 32},
+				{AddressPrefix: "1.2.3.4", PrefixLen: 32},
+			},
+		},
+		{
+			name:     "test for empty value",
+			cidrs:    []string{},
+			expected: []EnvoyCIDR{},
+		},
+		{
+			name:    "all invalid prefixes are reported",
+			cidrs:   []string{"10.0.0.0/33", "10.0.0.0/8", "invalid"},
+			errStrs: []string{"10.0.0.0/33", "invalid"},
+		},
+	}
+	for _, tt := range tests {
+		result, err := ToEnvoyCIDRs(tt.cidrs)
+		if len(tt.errStrs) == 0 && err != nil {
+			t.Errorf("Test %s failed, expected success, got: %v", tt.name, err)
+		}
+		for _, s := range tt.errStrs {
+			if err == nil || !strings.Contains(err.Error(), s) {
+				t.Errorf("Test %s failed, expected error mentioning %q, got: %v", tt.name, s, err)
+			}
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestHostPrefix(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+		wantErr  bool
+	}{
+		{ip: "1.2.3.4", expected: "1.2.3.4/32"},
+		{ip: "2001:db8::1", expected: "2001:db8::1/128"},
+		{ip: "fe80::1%eth0", expected: "fe80::1/128"},
+		{ip: "invalidip", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := HostPrefix(tt.ip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.ip, tt.wantErr, err)
+		}
+		if !tt.wantErr && result.String() != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestValidateCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		errStrs []string
+	}{
+		{
+			name:  "valid prefixes",
+			cidrs: []string{"10.0.0.0/8", "2001:db8::/32"},
+		},
+		{
+			name:  "test for empty value",
+			cidrs: nil,
+		},
+		{
+			name:    "every invalid prefix is reported",
+			cidrs:   []string{"10.0.0.0/8", "10.0.0.0/33", "1.2.3.4", "2001:db8::/32"},
+			errStrs: []string{`invalid CIDR "10.0.0.0/33" at index 1`, `invalid CIDR "1.2.3.4" at index 2`},
+		},
+	}
+	for _, tt := range tests {
+		err := ValidateCIDRs(tt.cidrs)
+		if len(tt.errStrs) == 0 && err != nil {
+			t.Errorf("Test %s failed, expected success, got: %v", tt.name, err)
+		}
+		for _, s := range tt.errStrs {
+			if err == nil || !strings.Contains(err.Error(), s) {
+				t.Errorf("Test %s failed, expected error mentioning %q, got: %v", tt.name, s, err)
+			}
+		}
+	}
+}
+
+func TestRangeIter(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidr     string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "ipv4 /30",
+			cidr:     "10.0.0.0/30",
+			expected: []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:     "host bits are masked",
+			cidr:     "10.0.0.6/31",
+			expected: []string{"10.0.0.6", "10.0.0.7"},
+		},
+		{
+			name:     "single host",
+			cidr:     "1.2.3.4/32",
+			expected: []string{"1.2.3.4"},
+		},
+		{
+			name:     "end of the address space",
+			cidr:     "255.255.255.254/31",
+			expected: []string{"255.255.255.254", "255.255.255.255"},
+		},
+		{
+			name:     "ipv6 /126",
+			cidr:     "2001:db8::/126",
+			expected: []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"},
+		},
+		{
+			name:    "invalid prefix",
+			cidr:    "invalid",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		next, err := RangeIter(tt.cidr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		var result []string
+		for addr, ok := next(); ok; addr, ok = next() {
+			result = append(result, addr.String())
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+		if _, ok := next(); ok {
+			t.Errorf("Test %s failed, expected exhausted iterator to stay exhausted", tt.name)
+		}
+	}
+}
+
+func TestMatchingCIDR(t *testing.T) {
+	cidrs := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "0.0.0.0/0", "2001:db8::/32", "2001:db8:1::/48"}
+	tests := []struct {
+		name     string
+		ip       string
+		cidrs    []string
+		expected string
+		matched  bool
+		wantErr  bool
+	}{
+		{name: "longest prefix wins", ip: "10.1.2.3", cidrs: cidrs, expected: "10.1.2.0/24", matched: true},
+		{name: "shorter prefix", ip: "10.1.3.3", cidrs: cidrs, expected: "10.1.0.0/16", matched: true},
+		{name: "default route", ip: "192.168.0.1", cidrs: cidrs, expected: "0.0.0.0/0", matched: true},
+		{name: "ipv6", ip: "2001:db8:1::1", cidrs: cidrs, expected: "2001:db8:1::/48", matched: true},
+		{name: "mapped ip", ip: "::ffff:10.1.2.3", cidrs: cidrs, expected: "10.1.2.0/24", matched: true},
+		{name: "zoned ip", ip: "fe80::1%eth0", cidrs: []string{"fe80::/10"}, expected: "fe80::/10", matched: true},
+		{
+			name:     "mapped prefix",
+			ip:       "10.1.2.3",
+			cidrs:    []string{"10.0.0.0/8", "::ffff:10.1.0.0/112"},
+			expected: "::ffff:10.1.0.0/112",
+			matched:  true,
+		},
+		{name: "no match", ip: "2001:db9::1", cidrs: cidrs},
+		{name: "empty list", ip: "10.0.0.1", cidrs: nil},
+		{name: "malformed cidr", ip: "10.0.0.1", cidrs: []string{"10.0.0.0/8", "invalid"}, wantErr: true},
+		{name: "invalid ip", ip: "invalidip", cidrs: cidrs, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, matched, err := MatchingCIDR(tt.ip, tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected || matched != tt.matched {
+			t.Errorf("Test %s failed, expected: %q %t got: %q %t", tt.name, tt.expected, tt.matched, result, matched)
+		}
+	}
+}
+
+func TestInPodCIDR(t *testing.T) {
+	podCIDRs := []string{"10.244.1.0/24", "fd00:10:244:1::/64"}
+	tests := []struct {
+		name     string
+		ip       string
+		cidrs    []string
+		expected bool
+		wantErr  bool
+	}{
+		{name: "ipv4 in range", ip: "10.244.1.17", cidrs: podCIDRs, expected: true},
+		{name: "ipv6 in range", ip: "fd00:10:244:1::17", cidrs: podCIDRs, expected: true},
+		{name: "zoned ipv6 in range", ip: "fd00:10:244:1::17%eth0", cidrs: podCIDRs, expected: true},
+		{name: "outside range", ip: "10.244.2.17", cidrs: podCIDRs, expected: false},
+		{name: "no pod cidrs", ip: "10.244.1.17", cidrs: nil, expected: false},
+		{name: "malformed cidr", ip: "10.244.1.17", cidrs: []string{"10.244.1.0/33"}, wantErr: true},
+		{name: "invalid ip", ip: "invalidip", cidrs: podCIDRs, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := InPodCIDR(tt.ip, tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestPrefixToNetmask(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+		wantErr  bool
+	}{
+		{cidr: "10.0.0.0/24", expected: "255.255.255.0"},
+		{cidr: "10.0.0.1/20", expected: "255.255.240.0"},
+		{cidr: "0.0.0.0/0", expected: "0.0.0.0"},
+		{cidr: "1.2.3.4/32", expected: "255.255.255.255"},
+		{cidr: "172.16.0.0/12", expected: "255.240.0.0"},
+		{cidr: "2001:db8::/32", wantErr: true},
+		{cidr: "10.0.0.0", wantErr: true},
+		{cidr: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := PrefixToNetmask(tt.cidr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.cidr, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.cidr, tt.expected, result)
+		}
+	}
+}
+
+func TestNetmaskToPrefix(t *testing.T) {
+	tests := []struct {
+		ip       string
+		mask     string
+		expected string
+		wantErr  bool
+	}{
+		{ip: "10.0.0.1", mask: "255.255.255.0", expected: "10.0.0.1/24"},
+		{ip: "172.16.0.0", mask: "255.240.0.0", expected: "172.16.0.0/12"},
+		{ip: "0.0.0.0", mask: "0.0.0.0", expected: "0.0.0.0/0"},
+		{ip: "1.2.3.4", mask: "255.255.255.255", expected: "1.2.3.4/32"},
+		{ip: "::ffff:10.0.0.1", mask: "255.255.0.0", expected: "10.0.0.1/16"},
+		{ip: "10.0.0.1", mask: "255.0.255.0", wantErr: true},
+		{ip: "10.0.0.1", mask: "0.255.255.255", wantErr: true},
+		{ip: "10.0.0.1", mask: "ffff:ffff::", wantErr: true},
+		{ip: "2001:db8::1", mask: "255.255.255.0", wantErr: true},
+		{ip: "invalidip", mask: "255.255.255.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := NetmaskToPrefix(tt.ip, tt.mask)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s %s failed, expected error: %t got: %v", tt.ip, tt.mask, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s %s failed, expected: %v got: %v", tt.ip, tt.mask, tt.expected, result)
+		}
+	}
+}
+
+func TestGroupBySubnet(t *testing.T) {
+	tests := []struct {
+		name        string
+		addrs       []string
+		v4PrefixLen int
+		v6PrefixLen int
+		expected    map[string][]string
+		wantErr     bool
+	}{
+		{
+			name:        "mixed families",
+			addrs:       []string{"10.0.1.1", "10.0.2.1", "10.0.1.2", "::ffff:10.0.2.2", "2001:db8::1", "2001:db8::2", "fe80::1%eth0"},
+			v4PrefixLen: 24,
+			v6PrefixLen: 64,
+			expected: map[string][]string{
+				"10.0.1.0/24":   {"10.0.1.1", "10.0.1.2"},
+				"10.0.2.0/24":   {"10.0.2.1", "::ffff:10.0.2.2"},
+				"2001:db8::/64": {"2001:db8::1", "2001:db8::2"},
+				"fe80::/64":     {"fe80::1%eth0"},
+			},
+		},
+		{
+			name:        "IPv6 prefix length",
+			addrs:       []string{"2001:db8:1::1", "2001:db8:1::2", "2001:db8:2::1"},
+			v4PrefixLen: 24,
+			v6PrefixLen: 48,
+			expected: map[string][]string{
+				"2001:db8:1::/48": {"2001:db8:1::1", "2001:db8:1::2"},
+				"2001:db8:2::/48": {"2001:db8:2::1"},
+			},
+		},
+		{
+			name:        "too long for IPv4",
+			addrs:       []string{"10.0.0.1"},
+			v4PrefixLen: 48,
+			v6PrefixLen: 64,
+			wantErr:     true,
+		},
+		{
+			name:        "too long for IPv6",
+			addrs:       []string{"2001:db8::1"},
+			v4PrefixLen: 24,
+			v6PrefixLen: 129,
+			wantErr:     true,
+		},
+		{
+			name:        "negative prefix length",
+			addrs:       []string{"10.0.0.1"},
+			v4PrefixLen: -1,
+			v6PrefixLen: 64,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid prefix length without addresses",
+			addrs:       nil,
+			v4PrefixLen: 500,
+			v6PrefixLen: 64,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid address",
+			addrs:       []string{"invalidip"},
+			v4PrefixLen: 24,
+			v6PrefixLen: 64,
+			wantErr:     true,
+		},
+		{
+			name:        "test for empty value",
+			addrs:       nil,
+			v4PrefixLen: 24,
+			v6PrefixLen: 64,
+			expected:    map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		result, err := GroupBySubnet(tt.addrs, tt.v4PrefixLen, tt.v6PrefixLen)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		errStrs  []string
+	}{
+		{
+			name:     "comma separated",
+			input:    "10.0.0.0/8,2001:db8::/32",
+			expected: []string{"10.0.0.0/8", "2001:db8::/32"},
+		},
+		{
+			name:     "mixed separators and empty entries",
+			input:    " 10.0.0.0/8, 172.16.0.0/12\t192.168.0.0/16,,\n2001:db8::/32 ",
+			expected: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "2001:db8::/32"},
+		},
+		{
+			name:     "empty",
+			input:    " , ",
+			expected: []string{},
+		},
+		{
+			name:    "every invalid entry is reported",
+			input:   "10.0.0.0/8, 10.0.0.0/33 1.2.3.4",
+			errStrs: []string{`invalid CIDR "10.0.0.0/33"`, `invalid CIDR "1.2.3.4"`},
+		},
+	}
+	for _, tt := range tests {
+		prefixes, err := ParseCIDRList(tt.input)
+		if len(tt.errStrs) == 0 && err != nil {
+			t.Errorf("Test %s failed, expected success, got: %v", tt.name, err)
+		}
+		for _, s := range tt.errStrs {
+			if err == nil || !strings.Contains(err.Error(), s) {
+				t.Errorf("Test %s failed, expected error mentioning %q, got: %v", tt.name, s, err)
+			}
+		}
+		if len(tt.errStrs) > 0 {
+			continue
+		}
+		result := make([]string, 0, len(prefixes))
+		for _, p := range prefixes {
+			result = append(result, p.String())
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestFirstUsableAddr(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+		wantErr  bool
+	}{
+		{cidr: "10.0.0.0/24", expected: "10.0.0.1"},
+		{cidr: "10.0.0.77/24", expected: "10.0.0.1"},
+		{cidr: "10.0.0.4/30", expected: "10.0.0.5"},
+		{cidr: "10.0.0.4/31", expected: "10.0.0.4"},
+		{cidr: "10.0.0.5/32", expected: "10.0.0.5"},
+		{cidr: "0.0.0.0/0", expected: "0.0.0.1"},
+		{cidr: "::ffff:10.0.0.0/120", expected: "10.0.0.1"},
+		{cidr: "2001:db8::/64", expected: "2001:db8::"},
+		{cidr: "2001:db8::5/127", expected: "2001:db8::4"},
+		{cidr: "2001:db8::5/128", expected: "2001:db8::5"},
+		{cidr: "10.0.0.0", wantErr: true},
+		{cidr: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := FirstUsableAddr(tt.cidr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.cidr, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.cidr, tt.expected, result)
+		}
+	}
+}
+
+func TestCanonicalCIDR(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+		wantErr  bool
+	}{
+		{cidr: "10.0.0.5/24", expected: "10.0.0.0/24"},
+		{cidr: "10.0.0.0/24", expected: "10.0.0.0/24"},
+		{cidr: "10.0.0.5/32", expected: "10.0.0.5/32"},
+		{cidr: "10.255.0.1/0", expected: "0.0.0.0/0"},
+		{cidr: "2001:0db8::5/64", expected: "2001:db8::/64"},
+		{cidr: "10.0.0.5", wantErr: true},
+		{cidr: "10.0.0.5/33", wantErr: true},
+		{cidr: "fe80::1%eth0/64", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := CanonicalCIDR(tt.cidr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.cidr, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.cidr, tt.expected, result)
+		}
+	}
+}
+
+func TestNetworkForAddress(t *testing.T) {
+	networks := map[string][]string{
+		"network1": {"10.0.0.0/8", "2001:db8::/32"},
+		"network2": {"10.1.0.0/16", "invalid"},
+		"network3": {"10.1.2.0/24", "::ffff:192.168.0.0/112"},
+		"network4": {"10.1.2.0/24"},
+	}
+	tests := []struct {
+		ip       string
+		expected string
+		ok       bool
+	}{
+		{ip: "10.200.0.1", expected: "network1", ok: true},
+		{ip: "10.1.200.1", expected: "network2", ok: true},
+		{ip: "10.1.2.3", expected: "network3", ok: true},
+		{ip: "::ffff:10.1.200.1", expected: "network2", ok: true},
+		{ip: "192.168.3.4", expected: "network3", ok: true},
+		{ip: "2001:db8::1", expected: "network1", ok: true},
+		{ip: "172.16.0.1", ok: false},
+		{ip: "invalidip", ok: false},
+	}
+	for _, tt := range tests {
+		result, ok := NetworkForAddress(tt.ip, networks)
+		if result != tt.expected || ok != tt.ok {
+			t.Errorf("Test %s failed, expected: %v %t got: %v %t", tt.ip, tt.expected, tt.ok, result, ok)
+		}
+	}
+
+	if _, err := NewNetworkMatcher(networks); err == nil || !strings.Contains(err.Error(), `"invalid"`) {
+		t.Errorf("expected error naming the malformed CIDR, got %v", err)
+	}
+	delete(networks, "network2")
+	m, err := NewNetworkMatcher(networks)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if result, ok := m.Network("10.1.200.1"); result != "network1" || !ok {
+		t.Errorf("expected network1, got %v %t", result, ok)
+	}
+}
+
+func TestCIDRListDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     []string
+		new     []string
+		added   []string
+		removed []string
+		wantErr bool
+	}{
+		{
+			name:    "added and removed",
+			old:     []string{"10.0.0.0/8", "192.168.0.0/16", "2001:db8::/32"},
+			new:     []string{"10.0.0.0/8", "172.16.0.0/12", "2001:db8::/48", "10.0.0.0/16"},
+			added:   []string{"10.0.0.0/16", "172.16.0.0/12", "2001:db8::/48"},
+			removed: []string{"192.168.0.0/16", "2001:db8::/32"},
+		},
+		{
+			name: "host bits are ignored",
+			old:  []string{"10.0.0.5/24", "2001:db8::1/64"},
+			new:  []string{"10.0.0.0/24", "2001:db8::/64", "10.0.0.7/24"},
+		},
+		{
+			name:    "canonical results",
+			old:     nil,
+			new:     []string{"10.0.0.5/24"},
+			added:   []string{"10.0.0.0/24"},
+			removed: nil,
+		},
+		{
+			name:    "invalid prefix",
+			old:     []string{"10.0.0.0/8"},
+			new:     []string{"10.0.0.0/33"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		added, removed, err := CIDRListDiff(tt.old, tt.new)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("Test %s failed, expected: %v %v got: %v %v", tt.name, tt.added, tt.removed, added, removed)
+		}
+	}
+}
+
+func TestFirstAddrNotIn(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		deny     []string
+		expected string
+		err      error
+		wantErr  bool
+	}{
+		{
+			name:     "skips denied addresses",
+			addrs:    []string{"10.0.0.5", "::ffff:10.0.0.6", "invalidip", "192.168.1.5", "172.16.0.1"},
+			deny:     []string{"10.0.0.0/24"},
+			expected: "192.168.1.5",
+		},
+		{
+			name:     "no deny list",
+			addrs:    []string{"10.0.0.5"},
+			expected: "10.0.0.5",
+		},
+		{
+			name:     "ipv6",
+			addrs:    []string{"2001:db8::1", "2001:db9::1"},
+			deny:     []string{"2001:db8::/32"},
+			expected: "2001:db9::1",
+		},
+		{
+			name:     "zoned addresses are denied",
+			addrs:    []string{"fe80::1%eth0", "fe80::2", "2001:db8::1%eth0"},
+			deny:     []string{"fe80::/10"},
+			expected: "2001:db8::1%eth0",
+		},
+		{
+			name:  "all denied",
+			addrs: []string{"10.0.0.5", "2001:db8::1"},
+			deny:  []string{"10.0.0.0/8", "2001:db8::/32"},
+			err:   ErrResolveNoAddress,
+		},
+		{
+			name:  "test for empty value",
+			addrs: nil,
+			deny:  []string{"10.0.0.0/8"},
+			err:   ErrResolveNoAddress,
+		},
+		{
+			name:    "malformed deny list",
+			addrs:   []string{"10.0.0.5"},
+			deny:    []string{"10.0.0.0/33"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := FirstAddrNotIn(tt.addrs, tt.deny)
+		if tt.err != nil && err != tt.err {
+			t.Errorf("Test %s failed, expected error: %v got: %v", tt.name, tt.err, err)
+		}
+		if tt.err == nil && (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// benchmarkingPrefix is the IPv4 block reserved for network benchmarking (RFC 2544).
+var benchmarkingPrefix = netip.MustParsePrefix("198.18.0.0/15")
+
+// sharedAddressSpacePrefix is the IPv4 shared address space used for carrier-grade NAT (RFC 6598).
+var sharedAddressSpacePrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// thisNetworkPrefix is the IPv4 "this host on this network" block (RFC 1122).
+var thisNetworkPrefix = netip.MustParsePrefix("0.0.0.0/8")
+
+// discardOnlyPrefix is the IPv6 discard-only address block (RFC 6666).
+var discardOnlyPrefix = netip.MustParsePrefix("100::/64")
+
+// limitedBroadcastAddr is the IPv4 limited broadcast address (RFC 919).
+var limitedBroadcastAddr = netip.MustParseAddr("255.255.255.255")
+
+// linkLocalMulticastPrefixes are the IPv4 local network control block (RFC 5771)
+// and the permanent IPv6 link-local multicast block (RFC 4291).
+var linkLocalMulticastPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("224.0.0.0/24"),
+	netip.MustParsePrefix("ff02::/16"),
+}
+
+// sixToFourPrefix is the IPv6 block used by 6to4 transition addresses (RFC 3056).
+var sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+
+// IsBenchmarkingIP returns true if ip is in the IPv4 benchmarking range
+// 198.18.0.0/15 (RFC 2544). Such addresses should never appear in real mesh
+// configuration. It returns false for IPv6 and invalid addresses.
+func IsBenchmarkingIP(ip string) bool {
+	return inIPv4Prefix(ip, benchmarkingPrefix)
+}
+
+// IsCGNATIP returns true if ip is a carrier-grade NAT address, i.e. in
+// 100.64.0.0/10. It returns false for IPv6 and invalid addresses.
+func IsCGNATIP(ip string) bool {
+	return inSharedAddressSpace(ip)
+}
+
+// IsSharedAddressSpace returns true if ip is in the RFC 6598 shared address
+// space 100.64.0.0/10. This is the same range as IsCGNATIP, for callers that
+// think in RFC 6598 terms. It returns false for IPv6 and invalid addresses.
+func IsSharedAddressSpace(ip string) bool {
+	return inSharedAddressSpace(ip)
+}
+
+func inSharedAddressSpace(ip string) bool {
+	return inIPv4Prefix(ip, sharedAddressSpacePrefix)
+}
+
+// IsThisNetwork returns true if ip is in the IPv4 "this host on this network"
+// block 0.0.0.0/8 (RFC 1122), which is wider than the single unspecified
+// address. It returns false for IPv6 and invalid addresses.
+func IsThisNetwork(ip string) bool {
+	return inIPv4Prefix(ip, thisNetworkPrefix)
+}
+
+// inIPv4Prefix returns true if ip is a valid IPv4 address contained in p.
+func inIPv4Prefix(ip string, p netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return addr.Is4() && p.Contains(addr)
+}
+
+// IsUnusableDestination returns true if ip can never be a routable endpoint
+// destination: the unspecified addresses 0.0.0.0 and ::, and the loopback
+// ranges 127.0.0.0/8 and ::1, including their IPv4-mapped IPv6 forms. It
+// returns false for invalid addresses, which should be caught by a separate
+// parse check.
+func IsUnusableDestination(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return addr.IsUnspecified() || addr.IsLoopback()
+}
+
+// IsDiscardOnly returns true if ip is in the IPv6 discard-only block 100::/64
+// (RFC 6666). Endpoints should never be in this range. It returns false for
+// IPv4 and invalid addresses.
+func IsDiscardOnly(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return addr.Is6() && discardOnlyPrefix.Contains(addr.WithZone(""))
+}
+
+// UsableAddrs returns the entries of ips that are routable unicast addresses,
+// dropping invalid, unspecified, loopback, link-local, multicast and broadcast
+// addresses. nil is returned if no address is usable, so callers can report
+// that case specifically.
+func UsableAddrs(ips []string) []string {
+	var out []string
+	for _, ip := range ips {
+		if IsRoutableIP(ip) {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// IsRoutableIP returns true if ip is a unicast address that can be routed
+// beyond the local host and link, i.e. it is valid and not unspecified,
+// loopback, link-local, multicast or the limited broadcast address. Private
+// addresses are routable, as are other special-purpose ranges such as
+// benchmarking or reserved addresses; use the dedicated predicates to exclude
+// those.
+func IsRoutableIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return !isNonUnicast(addr) && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+// isNonUnicast returns true if addr, which must already be unmapped, is a
+// multicast, unspecified or limited broadcast address.
+func isNonUnicast(addr netip.Addr) bool {
+	return addr.IsMulticast() || addr.IsUnspecified() || addr == limitedBroadcastAddr
+}
+
+// IsPrivateIP returns true if ip is in one of the private address ranges:
+// 10.0.0.0/8, 172.16.0.0/12 and 192.168.0.0/16 (RFC 1918) or fc00::/7 (RFC 4193).
+// It returns false for invalid addresses.
+func IsPrivateIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return addr.Unmap().IsPrivate()
+}
+
+// IsEUI64 returns true if ip is an IPv6 address whose interface identifier
+// was derived from a MAC address using modified EUI-64, as used by SLAAC,
+// which is recognizable by the ff:fe inserted in the middle of it. IPv4
+// addresses, including IPv4-mapped IPv6 addresses, are never EUI-64.
+func IsEUI64(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return false
+	}
+	b := addr.As16()
+	return b[11] == 0xff && b[12] == 0xfe
+}
+
+// IsLinkLocalMulticast returns true if ip is a link-local multicast address,
+// i.e. in 224.0.0.0/24 or ff02::/16. These are never forwarded by routers.
+func IsLinkLocalMulticast(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range linkLocalMulticastPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SixToFourExtractV4 returns the IPv4 address embedded in a 6to4 address,
+// which is carried in the 32 bits following the 2002::/16 prefix; for example
+// 2002:c000:204::1 embeds 192.0.2.4. The boolean is false if ip is not a 6to4
+// address.
+func SixToFourExtractV4(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || !sixToFourPrefix.Contains(addr.WithZone("")) {
+		return "", false
+	}
+	b := addr.As16()
+	return netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}).String(), true
+}
+
+// CrossesPublicBoundary returns true if traffic from src to dst leaves the
+// private network: src is a private address and dst is a public one, meaning
+// routable and neither private nor carrier-grade NAT. Such traffic may need
+// to go through an egress gateway. An error is returned if either address
+// cannot be parsed.
+func CrossesPublicBoundary(src, dst string) (bool, error) {
+	if _, err := netip.ParseAddr(src); err != nil {
+		return false, fmt.Errorf("invalid source address: %v", err)
+	}
+	if _, err := netip.ParseAddr(dst); err != nil {
+		return false, fmt.Errorf("invalid destination address: %v", err)
+	}
+	public := IsRoutableIP(dst) && !IsPrivateIP(dst) && !IsCGNATIP(dst)
+	return IsPrivateIP(src) && public, nil
+}
+
+// RequiresZone returns true if ip is a link-local IPv6 address, unicast or
+// multicast, without a zone. Such an address is ambiguous on a host with
+// several interfaces and cannot be used for connections, a common mistake
+// when fe80::1 is stored without its %iface suffix. It returns false for
+// other and invalid addresses, and for addresses that carry a zone.
+func RequiresZone(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Is4In6() || addr.Zone() != "" {
+		return false
+	}
+	return addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()
+}
+
+// HasNonUnicast returns true if any entry of ips is not a unicast address:
+// a multicast, unspecified or limited broadcast (255.255.255.255) address,
+// including their IPv4-mapped IPv6 forms. Invalid entries are ignored and
+// should be caught by a separate parse check.
+func HasNonUnicast(ips []string) bool {
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if isNonUnicast(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
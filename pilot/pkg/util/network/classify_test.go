@@ -0,0 +1,364 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsBenchmarkingIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "198.18.0.0", expected: true},
+		{ip: "198.19.255.255", expected: true},
+		{ip: "198.17.255.255", expected: false},
+		{ip: "198.20.0.0", expected: false},
+		{ip: "::ffff:198.18.0.1", expected: false},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsBenchmarkingIP(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsSharedAddressSpace(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "100.64.0.0", expected: true},
+		{ip: "100.127.255.255", expected: true},
+		{ip: "100.63.255.255", expected: false},
+		{ip: "100.128.0.0", expected: false},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsSharedAddressSpace(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, IsSharedAddressSpace expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+		if result := IsCGNATIP(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, IsCGNATIP expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsThisNetwork(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "0.0.0.0", expected: true},
+		{ip: "0.1.2.3", expected: true},
+		{ip: "0.255.255.255", expected: true},
+		{ip: "1.0.0.0", expected: false},
+		{ip: "::", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsThisNetwork(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsUnusableDestination(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "0.0.0.0", expected: true},
+		{ip: "::", expected: true},
+		{ip: "127.0.0.1", expected: true},
+		{ip: "127.255.255.254", expected: true},
+		{ip: "::1", expected: true},
+		{ip: "::ffff:127.0.0.1", expected: true},
+		{ip: "0.0.0.1", expected: false},
+		{ip: "10.0.0.1", expected: false},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsUnusableDestination(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsDiscardOnly(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "100::", expected: true},
+		{ip: "100::1", expected: true},
+		{ip: "100::ffff:ffff:ffff:ffff", expected: true},
+		{ip: "ff::ffff:ffff:ffff:ffff:ffff", expected: false},
+		{ip: "100:0:0:1::", expected: false},
+		{ip: "1.0.0.0", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsDiscardOnly(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestUsableAddrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected []string
+	}{
+		{
+			name: "unusable entries are dropped",
+			addrs: []string{
+				"10.0.0.1", "127.0.0.1", "0.0.0.0", "169.254.1.1", "224.0.0.1", "invalidip",
+				"2001:db8::1", "::1", "::", "fe80::1", "ff02::1", "::ffff:127.0.0.1", "::ffff:10.0.0.2",
+			},
+			expected: []string{"10.0.0.1", "2001:db8::1", "::ffff:10.0.0.2"},
+		},
+		{
+			name:     "broadcast is dropped",
+			addrs:    []string{"255.255.255.255", "::ffff:255.255.255.255", "10.0.0.255"},
+			expected: []string{"10.0.0.255"},
+		},
+		{
+			name:     "nothing usable",
+			addrs:    []string{"127.0.0.1", "fe80::1"},
+			expected: nil,
+		},
+		{
+			name:     "test for empty value",
+			addrs:    nil,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		if result := UsableAddrs(tt.addrs); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestIsRoutableIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "10.0.0.1", expected: true},
+		{ip: "8.8.8.8", expected: true},
+		{ip: "2001:db8::1", expected: true},
+		{ip: "::ffff:10.0.0.1", expected: true},
+		{ip: "198.18.0.1", expected: true},
+		{ip: "240.0.0.1", expected: true},
+		{ip: "255.255.255.255", expected: false},
+		{ip: "::ffff:255.255.255.255", expected: false},
+		{ip: "0.0.0.0", expected: false},
+		{ip: "::", expected: false},
+		{ip: "127.0.0.1", expected: false},
+		{ip: "::1", expected: false},
+		{ip: "169.254.1.1", expected: false},
+		{ip: "fe80::1%eth0", expected: false},
+		{ip: "224.0.0.1", expected: false},
+		{ip: "ff02::1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsRoutableIP(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsEUI64(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "fe80::21a:2bff:fe3c:4d5e", expected: true},
+		{ip: "2001:db8::21a:2bff:fe3c:4d5e", expected: true},
+		{ip: "fe80::21a:2bff:fe3c:4d5e%eth0", expected: true},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "2001:db8::a1b2:c3d4:e5f6:1234", expected: false},
+		{ip: "2001:db8::21a:2bfe:ff3c:4d5e", expected: false},
+		{ip: "::ffff:10.0.0.1", expected: false},
+		{ip: "10.0.0.1", expected: false},
+		{ip: "invalidip", expected: false},
+		{ip: "", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsEUI64(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestIsLinkLocalMulticast(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "224.0.0.0", expected: true},
+		{ip: "224.0.0.1", expected: true},
+		{ip: "224.0.0.251", expected: true},
+		{ip: "224.0.0.255", expected: true},
+		{ip: "224.0.1.0", expected: false},
+		{ip: "239.255.255.250", expected: false},
+		{ip: "::ffff:224.0.0.1", expected: true},
+		{ip: "ff02::1", expected: true},
+		{ip: "ff02::fb%eth0", expected: true},
+		{ip: "ff05::2", expected: false},
+		{ip: "ff12::1", expected: false},
+		{ip: "ff32::1", expected: false},
+		{ip: "fe80::1", expected: false},
+		{ip: "10.0.0.1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsLinkLocalMulticast(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestSixToFourExtractV4(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+		ok       bool
+	}{
+		{ip: "2002:c000:204::1", expected: "192.0.2.4", ok: true},
+		{ip: "2002:0a00:0001:1::abcd", expected: "10.0.0.1", ok: true},
+		{ip: "2002:ffff:ffff::", expected: "255.255.255.255", ok: true},
+		{ip: "2002::", expected: "0.0.0.0", ok: true},
+		{ip: "2003:c000:204::1", ok: false},
+		{ip: "2001:db8::1", ok: false},
+		{ip: "192.0.2.4", ok: false},
+		{ip: "invalidip", ok: false},
+	}
+	for _, tt := range tests {
+		result, ok := SixToFourExtractV4(tt.ip)
+		if result != tt.expected || ok != tt.ok {
+			t.Errorf("Test %s failed, expected: %v %t got: %v %t", tt.ip, tt.expected, tt.ok, result, ok)
+		}
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "10.1.2.3", expected: true},
+		{ip: "172.16.0.1", expected: true},
+		{ip: "172.32.0.1", expected: false},
+		{ip: "192.168.1.1", expected: true},
+		{ip: "::ffff:192.168.1.1", expected: true},
+		{ip: "fd00::1", expected: true},
+		{ip: "100.64.0.1", expected: false},
+		{ip: "8.8.8.8", expected: false},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := IsPrivateIP(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestCrossesPublicBoundary(t *testing.T) {
+	tests := []struct {
+		src      string
+		dst      string
+		expected bool
+		wantErr  bool
+	}{
+		{src: "10.0.0.1", dst: "8.8.8.8", expected: true},
+		{src: "fd00::1", dst: "2606:4700::1111", expected: true},
+		{src: "192.168.1.1", dst: "::ffff:8.8.8.8", expected: true},
+		{src: "10.0.0.1", dst: "10.0.0.2", expected: false},
+		{src: "10.0.0.1", dst: "100.64.0.1", expected: false},
+		{src: "10.0.0.1", dst: "127.0.0.1", expected: false},
+		{src: "10.0.0.1", dst: "169.254.169.254", expected: false},
+		{src: "10.0.0.1", dst: "224.0.0.1", expected: false},
+		{src: "8.8.4.4", dst: "8.8.8.8", expected: false},
+		{src: "invalidip", dst: "8.8.8.8", wantErr: true},
+		{src: "10.0.0.1", dst: "invalidip", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := CrossesPublicBoundary(tt.src, tt.dst)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s -> %s failed, expected error: %t got: %v", tt.src, tt.dst, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s -> %s failed, expected: %v got: %v", tt.src, tt.dst, tt.expected, result)
+		}
+	}
+}
+
+func TestRequiresZone(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "fe80::1", expected: true},
+		{ip: "febf::1", expected: true},
+		{ip: "ff02::1", expected: true},
+		{ip: "fe80::1%eth0", expected: false},
+		{ip: "ff02::1%eth0", expected: false},
+		{ip: "2001:db8::1", expected: false},
+		{ip: "fec0::1", expected: false},
+		{ip: "169.254.1.1", expected: false},
+		{ip: "::ffff:169.254.1.1", expected: false},
+		{ip: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := RequiresZone(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestHasNonUnicast(t *testing.T) {
+	tests := []struct {
+		name     string
+		ips      []string
+		expected bool
+	}{
+		{name: "unicast only", ips: []string{"10.0.0.1", "127.0.0.1", "fe80::1%eth0", "2001:db8::1"}, expected: false},
+		{name: "single multicast", ips: []string{"10.0.0.1", "224.0.0.251", "10.0.0.2"}, expected: true},
+		{name: "ipv6 multicast", ips: []string{"2001:db8::1", "ff02::1%eth0"}, expected: true},
+		{name: "unspecified", ips: []string{"10.0.0.1", "::"}, expected: true},
+		{name: "mapped unspecified", ips: []string{"::ffff:0.0.0.0"}, expected: true},
+		{name: "broadcast", ips: []string{"255.255.255.255"}, expected: true},
+		{name: "directed broadcast is not detected", ips: []string{"10.0.0.255"}, expected: false},
+		{name: "invalid entries are ignored", ips: []string{"invalidip", "10.0.0.1"}, expected: false},
+		{name: "test for empty value", ips: nil, expected: false},
+	}
+	for _, tt := range tests {
+		if result := HasNonUnicast(tt.ips); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCanReachTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	open := l.Addr().String()
+
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closed := closedListener.Addr().String()
+	closedListener.Close()
+
+	if !CanReachTCP(context.Background(), open, time.Second) {
+		t.Errorf("expected %s to be reachable", open)
+	}
+	if CanReachTCP(context.Background(), closed, time.Second) {
+		t.Errorf("expected %s to be unreachable", closed)
+	}
+	if CanReachTCP(context.Background(), "127.0.0.1", time.Second) {
+		t.Errorf("expected address without port to be unreachable")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if CanReachTCP(ctx, open, time.Second) {
+		t.Errorf("expected cancelled context to fail")
+	}
+}
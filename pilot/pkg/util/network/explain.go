@@ -0,0 +1,97 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// ResolveExplanation describes how ResolveAddrExplained arrived at its
+// result, e.g. for a debug endpoint answering why traffic went to an address.
+type ResolveExplanation struct {
+	Host       string             `json:"host"`
+	Policy     string             `json:"policy"`
+	Candidates []ResolveCandidate `json:"candidates"`
+	// Selected is the chosen address joined with the port, empty if none was chosen.
+	Selected string `json:"selected,omitempty"`
+}
+
+// ResolveCandidate is an address returned by the lookup.
+type ResolveCandidate struct {
+	Address string `json:"address"`
+	// Filtered is the reason the address was not eligible for selection, if any.
+	Filtered string `json:"filtered,omitempty"`
+	Selected bool   `json:"selected,omitempty"`
+}
+
+// ResolveAddrExplained resolves addr like ResolveAddr, selecting an address
+// according to policy, and additionally returns an explanation listing every
+// candidate address, why any were filtered out and which one was selected.
+// The explanation is populated as far as resolution got, so it is useful
+// even when an error is returned. ErrResolveNoAddress is returned if no
+// candidate is eligible.
+func ResolveAddrExplained(addr string, lookup LookupIPAddrType, policy IPFamilyPolicy) (string, ResolveExplanation, error) {
+	explanation := ResolveExplanation{Policy: policy.String()}
+	if policy < PreferIPv4 || policy > IPv6Only {
+		return "", explanation, fmt.Errorf("invalid IP family policy %d", policy)
+	}
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return "", explanation, err
+	}
+	explanation.Host = host
+	addrs, err := resolveHost(host, lookup)
+	if err != nil {
+		return "", explanation, err
+	}
+	selected := -1
+	for _, a := range addrs {
+		c := ResolveCandidate{Address: a.String()}
+		switch {
+		case !a.IsValid():
+			c.Filtered = "invalid address"
+		case policy == IPv4Only && !a.Is4(), policy == IPv6Only && !a.Is6():
+			c.Filtered = fmt.Sprintf("excluded by policy %v", policy)
+		default:
+			if selected < 0 || replaceSelection(addrs[selected], policy) {
+				selected = len(explanation.Candidates)
+			}
+		}
+		explanation.Candidates = append(explanation.Candidates, c)
+	}
+	if selected < 0 {
+		return "", explanation, ErrResolveNoAddress
+	}
+	explanation.Candidates[selected].Selected = true
+	explanation.Selected = netip.AddrPortFrom(addrs[selected], port).String()
+	return explanation.Selected, explanation, nil
+}
+
+// replaceSelection returns true if current, the address selected so far
+// under policy, should be replaced by a later eligible address. As in
+// ResolveAddr, the first address of the preferred family is kept, and
+// otherwise the last eligible address wins.
+func replaceSelection(current netip.Addr, policy IPFamilyPolicy) bool {
+	switch policy {
+	case PreferIPv4:
+		return !current.Is4()
+	case PreferIPv6:
+		return !current.Is6()
+	default:
+		// Only one family is eligible, so the first address is kept.
+		return false
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestResolveAddrExplained(t *testing.T) {
+	mixed := staticLookup("2001:db8::1", "1.2.3.4", "2001:db8::2", "1.2.3.5")
+	tests := []struct {
+		name       string
+		input      string
+		lookup     func(ctx context.Context, addr string) ([]netip.Addr, error)
+		policy     IPFamilyPolicy
+		expected   string
+		candidates []ResolveCandidate
+		err        error
+		wantErr    bool
+	}{
+		{
+			name:     "prefer ipv4",
+			input:    "www.foo.com:80",
+			lookup:   mixed,
+			policy:   PreferIPv4,
+			expected: "1.2.3.4:80",
+			candidates: []ResolveCandidate{
+				{Address: "2001:db8::1"},
+				{Address: "1.2.3.4", Selected: true},
+				{Address: "2001:db8::2"},
+				{Address: "1.2.3.5"},
+			},
+		},
+		{
+			name:     "prefer ipv6",
+			input:    "www.foo.com:80",
+			lookup:   mixed,
+			policy:   PreferIPv6,
+			expected: "[2001:db8::1]:80",
+			candidates: []ResolveCandidate{
+				{Address: "2001:db8::1", Selected: true},
+				{Address: "1.2.3.4"},
+				{Address: "2001:db8::2"},
+				{Address: "1.2.3.5"},
+			},
+		},
+		{
+			name:     "prefer ipv4 without ipv4 matches ResolveAddr",
+			input:    "www.foo.com:80",
+			lookup:   staticLookup("2001:db8::1", "2001:db8::2"),
+			policy:   PreferIPv4,
+			expected: "[2001:db8::2]:80",
+			candidates: []ResolveCandidate{
+				{Address: "2001:db8::1"},
+				{Address: "2001:db8::2", Selected: true},
+			},
+		},
+		{
+			name:     "ipv6 only",
+			input:    "www.foo.com:80",
+			lookup:   staticLookup("1.2.3.4", "2001:db8::1"),
+			policy:   IPv6Only,
+			expected: "[2001:db8::1]:80",
+			candidates: []ResolveCandidate{
+				{Address: "1.2.3.4", Filtered: "excluded by policy IPv6Only"},
+				{Address: "2001:db8::1", Selected: true},
+			},
+		},
+		{
+			name:   "everything filtered",
+			input:  "www.foo.com:80",
+			lookup: staticLookup("2001:db8::1"),
+			policy: IPv4Only,
+			candidates: []ResolveCandidate{
+				{Address: "2001:db8::1", Filtered: "excluded by policy IPv4Only"},
+			},
+			err: ErrResolveNoAddress,
+		},
+		{
+			name:     "ip literal",
+			input:    "[::ffff:1.2.3.4]:80",
+			policy:   PreferIPv6,
+			expected: "1.2.3.4:80",
+			candidates: []ResolveCandidate{
+				{Address: "1.2.3.4", Selected: true},
+			},
+		},
+		{
+			name:  "lookup failure",
+			input: "www.foo.com:80",
+			lookup: func(context.Context, string) ([]netip.Addr, error) {
+				return nil, errors.New("server failure")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid policy",
+			input:   "www.foo.com:80",
+			lookup:  mixed,
+			policy:  IPFamilyPolicy(42),
+			wantErr: true,
+		},
+		{
+			name:  "empty",
+			input: "",
+			err:   ErrResolveNoAddress,
+		},
+	}
+	for _, tt := range tests {
+		result, explanation, err := ResolveAddrExplained(tt.input, tt.lookup, tt.policy)
+		if tt.err != nil && err != tt.err {
+			t.Errorf("[%s] expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if tt.err == nil && (err != nil) != tt.wantErr {
+			t.Errorf("[%s] expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected || explanation.Selected != tt.expected {
+			t.Errorf("[%s] expected %q, got %q (explanation %q)", tt.name, tt.expected, result, explanation.Selected)
+		}
+		if !reflect.DeepEqual(explanation.Candidates, tt.candidates) {
+			t.Errorf("[%s] expected candidates %+v, got %+v", tt.name, tt.candidates, explanation.Candidates)
+		}
+	}
+}
+
+func TestResolveExplanationJSON(t *testing.T) {
+	_, explanation, err := ResolveAddrExplained("www.foo.com:80", staticLookup("2001:db8::1", "1.2.3.4"), IPv4Only)
+	if err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	b, err := json.Marshal(explanation)
+	if err != nil {
+		t.Fatalf("failed to marshal explanation: %v", err)
+	}
+	expected := `{"host":"www.foo.com","policy":"IPv4Only","candidates":[` +
+		`{"address":"2001:db8::1","filtered":"excluded by policy IPv4Only"},` +
+		`{"address":"1.2.3.4","selected":true}],"selected":"1.2.3.4:80"}`
+	if string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// IPFamily describes the IP family of an address or a set of addresses.
+// IPv4-mapped IPv6 addresses, such as ::ffff:10.0.0.1, belong to V4 in every
+// family helper of this package, matching how resolved addresses are unmapped.
+// AllIPv4 and AllIPv6 predate this and classify the literal form instead.
+type IPFamily int
+
+const (
+	// Unknown is used when the family cannot be determined, e.g. for invalid or empty input.
+	Unknown IPFamily = iota
+	// V4 is the IPv4 family.
+	V4
+	// V6 is the IPv6 family.
+	V6
+	// Mixed is used for a set of addresses containing both IPv4 and IPv6 addresses.
+	Mixed
+)
+
+func (f IPFamily) String() string {
+	switch f {
+	case V4:
+		return "IPv4"
+	case V6:
+		return "IPv6"
+	case Mixed:
+		return "Mixed"
+	default:
+		return "Unknown"
+	}
+}
+
+// IPFamilyPolicy controls which resolved addresses are eligible for
+// selection, and which family is preferred among them.
+type IPFamilyPolicy int
+
+const (
+	// PreferIPv4 selects the first IPv4 address, or the last IPv6 address if
+	// there is none. This is the behavior of ResolveAddr.
+	PreferIPv4 IPFamilyPolicy = iota
+	// PreferIPv6 selects the first IPv6 address, or the last IPv4 address if
+	// there is none.
+	PreferIPv6
+	// IPv4Only selects the first IPv4 address, ignoring IPv6 addresses.
+	IPv4Only
+	// IPv6Only selects the first IPv6 address, ignoring IPv4 addresses.
+	IPv6Only
+)
+
+func (p IPFamilyPolicy) String() string {
+	switch p {
+	case PreferIPv4:
+		return "PreferIPv4"
+	case PreferIPv6:
+		return "PreferIPv6"
+	case IPv4Only:
+		return "IPv4Only"
+	case IPv6Only:
+		return "IPv6Only"
+	default:
+		return "Unknown"
+	}
+}
+
+// familyOf returns the family of a single parsed address, treating
+// IPv4-mapped IPv6 addresses as IPv4.
+func familyOf(addr netip.Addr) IPFamily {
+	addr = addr.Unmap()
+	switch {
+	case addr.Is4():
+		return V4
+	case addr.Is6():
+		return V6
+	default:
+		return Unknown
+	}
+}
+
+// AssertFamily returns nil if ip is a valid address of the wanted family,
+// and a descriptive error otherwise. IPv4-mapped IPv6 addresses are
+// considered IPv4.
+func AssertFamily(ip string, want IPFamily) error {
+	if want != V4 && want != V6 {
+		return fmt.Errorf("invalid expected IP family %v", want)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	if got := familyOf(addr); got != want {
+		return fmt.Errorf("address %s is %v, expected %v", ip, got, want)
+	}
+	return nil
+}
+
+// UnspecifiedAddress returns the unspecified address literal for the family,
+// i.e. 0.0.0.0 for IPv4 and :: for IPv6.
+func UnspecifiedAddress(family IPFamily) (string, error) {
+	switch family {
+	case V4:
+		return netip.IPv4Unspecified().String(), nil
+	case V6:
+		return netip.IPv6Unspecified().String(), nil
+	default:
+		return "", fmt.Errorf("no unspecified address for IP family %v", family)
+	}
+}
+
+// IPFamilyOf returns the family of the addresses: V4 or V6 if all valid
+// addresses belong to that family, Mixed if both families are present, and
+// Unknown if there are no valid addresses. IPv4-mapped IPv6 addresses count as
+// IPv4.
+func IPFamilyOf(ips []string) IPFamily {
+	var hasV4, hasV6 bool
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		switch familyOf(addr) {
+		case V4:
+			hasV4 = true
+		case V6:
+			hasV6 = true
+		}
+	}
+	switch {
+	case hasV4 && hasV6:
+		return Mixed
+	case hasV4:
+		return V4
+	case hasV6:
+		return V6
+	default:
+		return Unknown
+	}
+}
+
+// FamilyChanged reports whether the family of the address list changed from
+// oldIPs to newIPs, e.g. when DNS starts returning only IPv6 addresses for a name
+// that previously resolved to IPv4. The old and new families are returned.
+func FamilyChanged(oldIPs, newIPs []string) (bool, IPFamily, IPFamily) {
+	oldFamily, newFamily := IPFamilyOf(oldIPs), IPFamilyOf(newIPs)
+	return oldFamily != newFamily, oldFamily, newFamily
+}
+
+// LoopbackAddress returns the loopback address literal for the family, i.e.
+// 127.0.0.1 for IPv4 and ::1 for IPv6.
+func LoopbackAddress(family IPFamily) (string, error) {
+	switch family {
+	case V4:
+		return "127.0.0.1", nil
+	case V6:
+		return netip.IPv6Loopback().String(), nil
+	default:
+		return "", fmt.Errorf("no loopback address for IP family %v", family)
+	}
+}
+
+// PreferredAddress selects a single address for a dual-stack workload: the
+// address of the preferred family if set, otherwise the other one. Non-empty
+// inputs must be valid addresses of their family, and at least one of them
+// must be set.
+func PreferredAddress(v4, v6 string, prefer IPFamily) (string, error) {
+	if prefer != V4 && prefer != V6 {
+		return "", fmt.Errorf("invalid preferred IP family %v", prefer)
+	}
+	if v4 == "" && v6 == "" {
+		return "", ErrResolveNoAddress
+	}
+	if v4 != "" {
+		if err := AssertFamily(v4, V4); err != nil {
+			return "", err
+		}
+	}
+	if v6 != "" {
+		if err := AssertFamily(v6, V6); err != nil {
+			return "", err
+		}
+	}
+	if (prefer == V4 && v4 != "") || v6 == "" {
+		return v4, nil
+	}
+	return v6, nil
+}
+
+// SplitByFamily splits ips into normalized IPv4 and IPv6 addresses, e.g. to
+// create one listener per family from a combined local address list.
+// IPv4-mapped IPv6 addresses are treated as IPv4, as in ResolveSplit. Entries
+// that are not valid addresses are returned unchanged in invalid so callers
+// can log them. Order is preserved.
+func SplitByFamily(ips []string) (v4 []string, v6 []string, invalid []string) {
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			invalid = append(invalid, ip)
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is4() {
+			v4 = append(v4, addr.String())
+		} else {
+			v6 = append(v6, addr.String())
+		}
+	}
+	return v4, v6, invalid
+}
+
+// DualStackBindSpecs returns the wildcard ip:port addresses needed to bind
+// port on every family present in the node's local addresses: one spec on a
+// single-stack node and two on a dual-stack node. An error is returned if the
+// port is invalid or local contains no valid address.
+func DualStackBindSpecs(port string, local []string) ([]string, error) {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+	var families []IPFamily
+	switch IPFamilyOf(local) {
+	case V4:
+		families = []IPFamily{V4}
+	case V6:
+		families = []IPFamily{V6}
+	case Mixed:
+		families = []IPFamily{V4, V6}
+	default:
+		return nil, fmt.Errorf("no IP family available to bind port %s", port)
+	}
+	specs := make([]string, 0, len(families))
+	for _, f := range families {
+		wildcard, _ := UnspecifiedAddress(f)
+		specs = append(specs, net.JoinHostPort(wildcard, port))
+	}
+	return specs, nil
+}
+
+// FamilyMismatch reports whether the IP families of listenerAddrs fail to
+// cover those of endpointAddrs, e.g. an IPv4-only listener in front of IPv6
+// endpoints, a common dual-stack misconfiguration. If so, the returned reason
+// names the missing family. Invalid addresses are ignored.
+func FamilyMismatch(listenerAddrs, endpointAddrs []string) (bool, string) {
+	listenerFamily, endpointFamily := IPFamilyOf(listenerAddrs), IPFamilyOf(endpointAddrs)
+	var missing []string
+	for _, f := range []IPFamily{V4, V6} {
+		if hasFamily(endpointFamily, f) && !hasFamily(listenerFamily, f) {
+			missing = append(missing, f.String())
+		}
+	}
+	if len(missing) == 0 {
+		return false, ""
+	}
+	return true, fmt.Sprintf("listeners have no %s address, but endpoints are %s",
+		strings.Join(missing, " or "), endpointFamily)
+}
+
+// hasFamily returns true if a set of addresses of family set contains addresses of family f.
+func hasFamily(set, f IPFamily) bool {
+	return set == f || set == Mixed
+}
+
+// ResolvedFamilyMatches returns whether resolved, the result of resolving
+// host, has the same IP family as host when host is an IP literal, e.g. to
+// catch a custom resolver returning an IPv4 address for an IPv6 literal.
+// Hostnames carry no family, so the result is always true for them. Both
+// host and resolved may include a port. IPv4-mapped addresses count as IPv4.
+// An error is returned if either cannot be parsed.
+func ResolvedFamilyMatches(host string, resolved string) (bool, error) {
+	h, err := hostOf(host)
+	if err != nil {
+		return false, err
+	}
+	r, err := hostOf(resolved)
+	if err != nil {
+		return false, err
+	}
+	resolvedAddr, err := netip.ParseAddr(r)
+	if err != nil {
+		return false, fmt.Errorf("invalid resolved address %q: %v", resolved, err)
+	}
+	hostAddr, err := netip.ParseAddr(h)
+	if err != nil {
+		return true, nil
+	}
+	return familyOf(hostAddr) == familyOf(resolvedAddr), nil
+}
@@ -0,0 +1,378 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssertFamily(t *testing.T) {
+	tests := []struct {
+		name   string
+		ip     string
+		want   IPFamily
+		errStr string
+	}{
+		{
+			name: "ipv4 matches",
+			ip:   "1.2.3.4",
+			want: V4,
+		},
+		{
+			name: "ipv6 matches",
+			ip:   "2001:db8::1",
+			want: V6,
+		},
+		{
+			name:   "ipv4 but ipv6 wanted",
+			ip:     "1.2.3.4",
+			want:   V6,
+			errStr: "address 1.2.3.4 is IPv4, expected IPv6",
+		},
+		{
+			name:   "ipv6 but ipv4 wanted",
+			ip:     "::1",
+			want:   V4,
+			errStr: "address ::1 is IPv6, expected IPv4",
+		},
+		{
+			name: "ipv4-mapped ipv6 is ipv4",
+			ip:   "::ffff:1.2.3.4",
+			want: V4,
+		},
+		{
+			name:   "ipv4-mapped ipv6 but ipv6 wanted",
+			ip:     "::ffff:1.2.3.4",
+			want:   V6,
+			errStr: "address ::ffff:1.2.3.4 is IPv4, expected IPv6",
+		},
+		{
+			name:   "mixed is not a valid expectation",
+			ip:     "1.2.3.4",
+			want:   Mixed,
+			errStr: "invalid expected IP family Mixed",
+		},
+		{
+			name:   "invalid ip address",
+			ip:     "invalidip",
+			want:   V4,
+			errStr: `ParseAddr("invalidip"): unable to parse IP`,
+		},
+	}
+	for _, tt := range tests {
+		err := AssertFamily(tt.ip, tt.want)
+		if tt.errStr == "" {
+			if err != nil {
+				t.Errorf("Test %s failed, expected success, got: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.errStr {
+			t.Errorf("Test %s failed, expected error %q, got: %v", tt.name, tt.errStr, err)
+		}
+	}
+}
+
+func TestUnspecifiedAddress(t *testing.T) {
+	tests := []struct {
+		family   IPFamily
+		expected string
+		wantErr  bool
+	}{
+		{family: V4, expected: "0.0.0.0"},
+		{family: V6, expected: "::"},
+		{family: Mixed, wantErr: true},
+		{family: Unknown, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := UnspecifiedAddress(tt.family)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %v failed, expected error: %t got: %v", tt.family, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %v failed, expected: %v got: %v", tt.family, tt.expected, result)
+		}
+	}
+}
+
+func TestIPFamilyOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		ips      []string
+		expected IPFamily
+	}{
+		{name: "ipv4 only", ips: []string{"1.1.1.1", "127.0.0.1"}, expected: V4},
+		{name: "ipv6 only", ips: []string{"1111:2222::1", "::1"}, expected: V6},
+		{name: "mixed ipv4 and ipv6", ips: []string{"::1", "127.0.0.1"}, expected: Mixed},
+		{name: "ipv4-mapped ipv6 is ipv4", ips: []string{"::ffff:10.0.0.1", "10.0.0.2"}, expected: V4},
+		{name: "ipv4-mapped ipv6 with ipv6", ips: []string{"::ffff:10.0.0.1", "2001:db8::1"}, expected: Mixed},
+		{name: "invalid entries are ignored", ips: []string{"invalidip", "::1"}, expected: V6},
+		{name: "test for invalid ip address", ips: []string{"invalidip"}, expected: Unknown},
+		{name: "test for empty value", ips: []string{}, expected: Unknown},
+	}
+	for _, tt := range tests {
+		if result := IPFamilyOf(tt.ips); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestFamilyChanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		old       []string
+		new       []string
+		changed   bool
+		oldFamily IPFamily
+		newFamily IPFamily
+	}{
+		{
+			name:      "unchanged ipv4",
+			old:       []string{"1.1.1.1"},
+			new:       []string{"2.2.2.2", "3.3.3.3"},
+			changed:   false,
+			oldFamily: V4,
+			newFamily: V4,
+		},
+		{
+			name:      "ipv4 to ipv6",
+			old:       []string{"1.1.1.1"},
+			new:       []string{"2001:db8::1"},
+			changed:   true,
+			oldFamily: V4,
+			newFamily: V6,
+		},
+		{
+			name:      "mixed to ipv6",
+			old:       []string{"1.1.1.1", "2001:db8::1"},
+			new:       []string{"2001:db8::1"},
+			changed:   true,
+			oldFamily: Mixed,
+			newFamily: V6,
+		},
+		{
+			name:      "ipv4 to mixed",
+			old:       []string{"1.1.1.1"},
+			new:       []string{"1.1.1.1", "2001:db8::1"},
+			changed:   true,
+			oldFamily: V4,
+			newFamily: Mixed,
+		},
+		{
+			name:      "empty to ipv4",
+			old:       nil,
+			new:       []string{"1.1.1.1"},
+			changed:   true,
+			oldFamily: Unknown,
+			newFamily: V4,
+		},
+	}
+	for _, tt := range tests {
+		changed, oldFamily, newFamily := FamilyChanged(tt.old, tt.new)
+		if changed != tt.changed || oldFamily != tt.oldFamily || newFamily != tt.newFamily {
+			t.Errorf("Test %s failed, expected: %t %v %v got: %t %v %v",
+				tt.name, tt.changed, tt.oldFamily, tt.newFamily, changed, oldFamily, newFamily)
+		}
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		family   IPFamily
+		expected string
+		wantErr  bool
+	}{
+		{family: V4, expected: "127.0.0.1"},
+		{family: V6, expected: "::1"},
+		{family: Mixed, wantErr: true},
+		{family: Unknown, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := LoopbackAddress(tt.family)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %v failed, expected error: %t got: %v", tt.family, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %v failed, expected: %v got: %v", tt.family, tt.expected, result)
+		}
+	}
+}
+
+func TestPreferredAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		v4       string
+		v6       string
+		prefer   IPFamily
+		expected string
+		wantErr  bool
+	}{
+		{name: "prefer ipv4", v4: "10.0.0.1", v6: "fd00::1", prefer: V4, expected: "10.0.0.1"},
+		{name: "prefer ipv6", v4: "10.0.0.1", v6: "fd00::1", prefer: V6, expected: "fd00::1"},
+		{name: "ipv4 preferred but missing", v6: "fd00::1", prefer: V4, expected: "fd00::1"},
+		{name: "ipv6 preferred but missing", v4: "10.0.0.1", prefer: V6, expected: "10.0.0.1"},
+		{name: "both empty", prefer: V4, wantErr: true},
+		{name: "ipv6 in ipv4 slot", v4: "fd00::1", prefer: V4, wantErr: true},
+		{name: "ipv4 in ipv6 slot", v4: "10.0.0.1", v6: "10.0.0.2", prefer: V4, wantErr: true},
+		{name: "invalid address", v4: "invalidip", v6: "fd00::1", prefer: V6, wantErr: true},
+		{name: "invalid preference", v4: "10.0.0.1", prefer: Mixed, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := PreferredAddress(tt.v4, tt.v6, tt.prefer)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestSplitByFamily(t *testing.T) {
+	v4, v6, invalid := SplitByFamily([]string{
+		"10.0.0.2", "2001:0db8::1", "invalidip", "::ffff:10.0.0.1", "fe80::1%eth0", "", "10.0.0.3",
+	})
+	if expected := []string{"10.0.0.2", "10.0.0.1", "10.0.0.3"}; !reflect.DeepEqual(v4, expected) {
+		t.Errorf("expected ipv4: %v got: %v", expected, v4)
+	}
+	if expected := []string{"2001:db8::1", "fe80::1%eth0"}; !reflect.DeepEqual(v6, expected) {
+		t.Errorf("expected ipv6: %v got: %v", expected, v6)
+	}
+	if expected := []string{"invalidip", ""}; !reflect.DeepEqual(invalid, expected) {
+		t.Errorf("expected invalid: %v got: %v", expected, invalid)
+	}
+}
+
+func TestDualStackBindSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     string
+		local    []string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "ipv4 only", port: "15001", local: []string{"10.0.0.1"}, expected: []string{"0.0.0.0:15001"}},
+		{name: "ipv6 only", port: "15001", local: []string{"fd00::1"}, expected: []string{"[::]:15001"}},
+		{name: "ipv4-mapped ipv6", port: "80", local: []string{"::ffff:10.0.0.1"}, expected: []string{"0.0.0.0:80"}},
+		{
+			name:     "dual stack",
+			port:     "15001",
+			local:    []string{"10.0.0.1", "fd00::1"},
+			expected: []string{"0.0.0.0:15001", "[::]:15001"},
+		},
+		{name: "no addresses", port: "15001", local: nil, wantErr: true},
+		{name: "only invalid addresses", port: "15001", local: []string{"invalidip"}, wantErr: true},
+		{name: "invalid port", port: "http", local: []string{"10.0.0.1"}, wantErr: true},
+		{name: "port out of range", port: "65536", local: []string{"10.0.0.1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := DualStackBindSpecs(tt.port, tt.local)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestFamilyMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners []string
+		endpoints []string
+		mismatch  bool
+		reason    string
+	}{
+		{
+			name:      "ipv4 listener and endpoints",
+			listeners: []string{"0.0.0.0"},
+			endpoints: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:      "dual-stack listener",
+			listeners: []string{"0.0.0.0", "::"},
+			endpoints: []string{"10.0.0.1", "2001:db8::1"},
+		},
+		{
+			name:      "dual-stack listener with ipv6 endpoints",
+			listeners: []string{"0.0.0.0", "::"},
+			endpoints: []string{"2001:db8::1"},
+		},
+		{
+			name:      "ipv4 listener with ipv6 endpoints",
+			listeners: []string{"0.0.0.0"},
+			endpoints: []string{"2001:db8::1"},
+			mismatch:  true,
+			reason:    "listeners have no IPv6 address, but endpoints are IPv6",
+		},
+		{
+			name:      "ipv6 listener with dual-stack endpoints",
+			listeners: []string{"::"},
+			endpoints: []string{"10.0.0.1", "2001:db8::1"},
+			mismatch:  true,
+			reason:    "listeners have no IPv4 address, but endpoints are Mixed",
+		},
+		{
+			name:      "no listeners",
+			listeners: []string{"invalidip"},
+			endpoints: []string{"10.0.0.1", "2001:db8::1"},
+			mismatch:  true,
+			reason:    "listeners have no IPv4 or IPv6 address, but endpoints are Mixed",
+		},
+		{
+			name:      "no endpoints",
+			listeners: []string{"0.0.0.0"},
+			endpoints: nil,
+		},
+	}
+	for _, tt := range tests {
+		mismatch, reason := FamilyMismatch(tt.listeners, tt.endpoints)
+		if mismatch != tt.mismatch || reason != tt.reason {
+			t.Errorf("Test %s failed, expected: %t %q got: %t %q", tt.name, tt.mismatch, tt.reason, mismatch, reason)
+		}
+	}
+}
+
+func TestResolvedFamilyMatches(t *testing.T) {
+	tests := []struct {
+		host     string
+		resolved string
+		expected bool
+		wantErr  bool
+	}{
+		{host: "1.2.3.4", resolved: "1.2.3.4", expected: true},
+		{host: "1.2.3.4:80", resolved: "1.2.3.4:80", expected: true},
+		{host: "[2001:db8::1]:80", resolved: "[2001:db8::1]:80", expected: true},
+		{host: "2001:db8::1", resolved: "1.2.3.4", expected: false},
+		{host: "1.2.3.4", resolved: "[2001:db8::1]:80", expected: false},
+		{host: "::ffff:1.2.3.4", resolved: "1.2.3.4", expected: true},
+		{host: "www.foo.com", resolved: "2001:db8::1", expected: true},
+		{host: "www.foo.com:80", resolved: "1.2.3.4:80", expected: true},
+		{host: "www.foo.com", resolved: "www.bar.com", wantErr: true},
+		{host: "1.2.3.4", resolved: "", wantErr: true},
+		{host: "", resolved: "1.2.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := ResolvedFamilyMatches(tt.host, tt.resolved)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s -> %s failed, expected error: %t got: %v", tt.host, tt.resolved, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s -> %s failed, expected: %v got: %v", tt.host, tt.resolved, tt.expected, result)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overridden in tests.
+var (
+	netInterfaces  = net.Interfaces
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		return iface.Addrs()
+	}
+	inet6AddrFlags = readInet6AddrFlags
+)
+
+// LocalAddresses returns the addresses assigned to all local interfaces, in
+// the form described by LocalAddressesMatching.
+func LocalAddresses() ([]string, error) {
+	addrs, err := LocalAddrsTyped()
+	if err != nil {
+		return nil, err
+	}
+	return addrsToStrings(addrs), nil
+}
+
+// LocalAddrsTyped is like LocalAddresses, but returns parsed addresses so
+// that callers classifying them further need not re-parse. Zones are preserved.
+func LocalAddrsTyped() ([]netip.Addr, error) {
+	return localAddrsMatching(func(net.Interface, netip.Addr) bool { return true })
+}
+
+// LocalAddressesMatching returns the addresses assigned to local interfaces
+// for which pred returns true. pred receives both the interface and the
+// address, so callers can filter on either, e.g. only interfaces named eth*
+// or only global IPv6 addresses. IPv4-mapped addresses are unwrapped and
+// link-local IPv6 addresses carry the interface name as their zone.
+func LocalAddressesMatching(pred func(iface net.Interface, addr netip.Addr) bool) ([]string, error) {
+	addrs, err := localAddrsMatching(pred)
+	if err != nil {
+		return nil, err
+	}
+	return addrsToStrings(addrs), nil
+}
+
+func localAddrsMatching(pred func(iface net.Interface, addr netip.Addr) bool) ([]netip.Addr, error) {
+	ifaces, err := netInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []netip.Addr
+	for _, iface := range ifaces {
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			addr, ok := toNetipAddr(iface, a)
+			if ok && pred(iface, addr) {
+				out = append(out, addr)
+			}
+		}
+	}
+	return out, nil
+}
+
+// toNetipAddr converts an interface address to a netip.Addr.
+func toNetipAddr(iface net.Interface, a net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch v := a.(type) {
+	case *net.IPNet:
+		ip = v.IP
+	case *net.IPAddr:
+		ip = v.IP
+	default:
+		return netip.Addr{}, false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if addr.Is6() && (addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()) {
+		addr = addr.WithZone(iface.Name)
+	}
+	return addr, true
+}
+
+var (
+	defaultFamilyMu sync.Mutex
+	defaultFamily   IPFamily
+)
+
+// DefaultFamily returns the likely default IP family of the node, based on
+// the global unicast (including private and ULA) addresses of its up local
+// interfaces: V4, V6, or Mixed for dual-stack nodes. Loopback and link-local
+// addresses are ignored. Unknown is returned if no such address is found.
+// The first known result is cached; Unknown is not, so that a node whose
+// interfaces are not configured yet is inspected again on the next call.
+func DefaultFamily() IPFamily {
+	defaultFamilyMu.Lock()
+	defer defaultFamilyMu.Unlock()
+	if defaultFamily == Unknown {
+		defaultFamily = detectDefaultFamily()
+	}
+	return defaultFamily
+}
+
+func detectDefaultFamily() IPFamily {
+	addrs, err := LocalAddressesMatching(func(iface net.Interface, addr netip.Addr) bool {
+		return iface.Flags&net.FlagUp != 0 && addr.IsGlobalUnicast()
+	})
+	if err != nil {
+		return Unknown
+	}
+	return IPFamilyOf(addrs)
+}
+
+// IPv6 address flags, as reported in /proc/net/if_inet6 on Linux.
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// ifaceAddr identifies an address on a specific interface.
+type ifaceAddr struct {
+	iface string
+	addr  netip.Addr
+}
+
+// readInet6AddrFlags returns the flags of the local IPv6 addresses. It
+// returns no flags, rather than an error, on systems without /proc/net/if_inet6.
+func readInet6AddrFlags() (map[ifaceAddr]uint32, error) {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseInet6AddrFlags(f)
+}
+
+// parseInet6AddrFlags parses the /proc/net/if_inet6 format: one address per
+// line, with the address, interface index, prefix length, scope and flags in
+// hex followed by the interface name. Malformed lines are skipped.
+func parseInet6AddrFlags(r io.Reader) (map[ifaceAddr]uint32, error) {
+	flags := map[ifaceAddr]uint32{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(b)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		flags[ifaceAddr{iface: fields[5], addr: addr}] = uint32(v)
+	}
+	return flags, scanner.Err()
+}
+
+// MostStableLocalAddress returns the local address least likely to change,
+// suitable for advertising as the identity of this node. Candidates are
+// ranked in the following order, ties going to the first address found:
+//
+//  1. permanent global unicast addresses (including private and ULA
+//     addresses); IPv4 addresses are always considered permanent
+//  2. temporary IPv6 addresses, such as privacy extension addresses
+//  3. deprecated or tentative IPv6 addresses
+//  4. link-local unicast addresses
+//  5. loopback addresses
+//
+// Multicast and unspecified addresses, and addresses of interfaces that are
+// down, are never returned. IPv6 address flags
+// are only available on Linux; elsewhere every IPv6 address is considered
+// permanent. An error is returned if no candidate exists.
+func MostStableLocalAddress() (string, error) {
+	flags, err := inet6AddrFlags()
+	if err != nil {
+		return "", err
+	}
+	ifaces, err := netInterfaces()
+	if err != nil {
+		return "", err
+	}
+	best, bestRank := netip.Addr{}, -1
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			return "", err
+		}
+		for _, a := range addrs {
+			addr, ok := toNetipAddr(iface, a)
+			if !ok {
+				continue
+			}
+			rank := stabilityRank(addr, flags[ifaceAddr{iface: iface.Name, addr: addr.WithZone("")}])
+			if rank >= 0 && (bestRank < 0 || rank < bestRank) {
+				best, bestRank = addr, rank
+			}
+		}
+	}
+	if bestRank < 0 {
+		return "", errors.New("no usable local address found")
+	}
+	return best.String(), nil
+}
+
+// stabilityRank returns the preference of addr for MostStableLocalAddress,
+// lower being better, or -1 if addr is not a candidate.
+func stabilityRank(addr netip.Addr, flags uint32) int {
+	switch {
+	case addr.IsGlobalUnicast():
+		switch {
+		case flags&(ifaFlagDeprecated|ifaFlagTentative) != 0:
+			return 2
+		case flags&ifaFlagTemporary != 0:
+			return 1
+		default:
+			return 0
+		}
+	case addr.IsLinkLocalUnicast():
+		return 3
+	case addr.IsLoopback():
+		return 4
+	default:
+		return -1
+	}
+}
+
+// localPrefixTTL is how long the local interface prefixes are cached by InLocalSubnet.
+const localPrefixTTL = 5 * time.Second
+
+// prefixCache caches the subnets configured on local interfaces.
+type prefixCache struct {
+	ttl time.Duration
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	prefixes []netip.Prefix
+	fetched  time.Time
+}
+
+var localPrefixes = &prefixCache{ttl: localPrefixTTL, now: time.Now}
+
+// get returns the cached prefixes, enumerating the interfaces again if the
+// cache is empty or expired. Failures are not cached.
+func (c *prefixCache) get() ([]netip.Prefix, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && c.now().Sub(c.fetched) < c.ttl {
+		return c.prefixes, nil
+	}
+	prefixes, err := localInterfacePrefixes()
+	if err != nil {
+		return nil, err
+	}
+	c.prefixes, c.fetched = prefixes, c.now()
+	return prefixes, nil
+}
+
+// localInterfacePrefixes returns the subnets configured on local interfaces.
+// Addresses without a mask are treated as single-host prefixes.
+func localInterfacePrefixes() ([]netip.Prefix, error) {
+	ifaces, err := netInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []netip.Prefix
+	for _, iface := range ifaces {
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			addr, ok := toNetipAddr(iface, a)
+			if !ok {
+				continue
+			}
+			addr = addr.WithZone("")
+			bits := addr.BitLen()
+			if ipNet, ok := a.(*net.IPNet); ok {
+				ones, size := ipNet.Mask.Size()
+				if size == 0 {
+					continue
+				}
+				// IPv4 masks may be given in their 16 byte form.
+				bits = ones - (size - addr.BitLen())
+			}
+			if p, err := addr.Prefix(bits); err == nil {
+				out = append(out, p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// InLocalSubnet returns true if ip is on-link, i.e. within a subnet
+// configured on one of the local interfaces. Interface prefixes are cached
+// for a few seconds. An error is returned if ip is invalid or the interfaces
+// cannot be enumerated.
+func InLocalSubnet(ip string) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, err
+	}
+	prefixes, err := localPrefixes.get()
+	if err != nil {
+		return false, err
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
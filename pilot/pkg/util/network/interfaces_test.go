@@ -0,0 +1,456 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeInterfaces replaces the local interfaces with ifaces for the duration of the test.
+func fakeInterfaces(t *testing.T, ifaces map[string][]net.Addr) {
+	t.Helper()
+	oldInterfaces, oldAddrs := netInterfaces, interfaceAddrs
+	t.Cleanup(func() {
+		netInterfaces, interfaceAddrs = oldInterfaces, oldAddrs
+	})
+	var list []net.Interface
+	for _, name := range []string{"lo", "eth0", "eth1", "docker0"} {
+		if _, f := ifaces[name]; f {
+			list = append(list, net.Interface{Name: name, Flags: net.FlagUp})
+		}
+	}
+	netInterfaces = func() ([]net.Interface, error) {
+		return list, nil
+	}
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		return ifaces[iface.Name], nil
+	}
+}
+
+// setInterfacesDown marks the named interfaces installed by fakeInterfaces as down.
+func setInterfacesDown(names ...string) {
+	list, _ := netInterfaces()
+	for i := range list {
+		for _, name := range names {
+			if list[i].Name == name {
+				list[i].Flags &^= net.FlagUp
+			}
+		}
+	}
+	netInterfaces = func() ([]net.Interface, error) {
+		return list, nil
+	}
+}
+
+func ipNet(cidr string) *net.IPNet {
+	ip, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	n.IP = ip
+	return n
+}
+
+func TestLocalAddressesMatching(t *testing.T) {
+	fakeInterfaces(t, map[string][]net.Addr{
+		"lo":      {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+		"eth0":    {ipNet("10.0.0.5/24"), ipNet("fe80::1/64"), ipNet("2001:db8::5/64")},
+		"eth1":    {&net.IPAddr{IP: net.ParseIP("192.168.1.5")}},
+		"docker0": {ipNet("172.17.0.1/16")},
+	})
+
+	tests := []struct {
+		name     string
+		pred     func(iface net.Interface, addr netip.Addr) bool
+		expected []string
+	}{
+		{
+			name: "all",
+			pred: func(net.Interface, netip.Addr) bool { return true },
+			expected: []string{
+				"127.0.0.1", "::1", "10.0.0.5", "fe80::1%eth0", "2001:db8::5", "192.168.1.5", "172.17.0.1",
+			},
+		},
+		{
+			name: "interfaces named eth*",
+			pred: func(iface net.Interface, _ netip.Addr) bool {
+				return strings.HasPrefix(iface.Name, "eth")
+			},
+			expected: []string{"10.0.0.5", "fe80::1%eth0", "2001:db8::5", "192.168.1.5"},
+		},
+		{
+			name: "global ipv6",
+			pred: func(_ net.Interface, addr netip.Addr) bool {
+				return addr.Is6() && addr.IsGlobalUnicast()
+			},
+			expected: []string{"2001:db8::5"},
+		},
+	}
+	for _, tt := range tests {
+		result, err := LocalAddressesMatching(tt.pred)
+		if err != nil {
+			t.Fatalf("Test %s failed, expected success, got: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestLocalAddressesMatchingError(t *testing.T) {
+	fakeInterfaces(t, nil)
+	netInterfaces = func() ([]net.Interface, error) {
+		return nil, errors.New("enumeration failed")
+	}
+	if _, err := LocalAddressesMatching(func(net.Interface, netip.Addr) bool { return true }); err == nil {
+		t.Errorf("expected interface enumeration error")
+	}
+}
+
+func TestLocalAddrsTyped(t *testing.T) {
+	fakeInterfaces(t, map[string][]net.Addr{
+		"lo":   {ipNet("127.0.0.1/8")},
+		"eth0": {ipNet("10.0.0.5/24"), ipNet("fe80::1/64"), &net.IPAddr{IP: net.ParseIP("::ffff:10.0.0.6")}},
+	})
+	expected := []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("10.0.0.5"),
+		netip.MustParseAddr("fe80::1%eth0"),
+		netip.MustParseAddr("10.0.0.6"),
+	}
+	result, err := LocalAddrsTyped()
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected: %v got: %v", expected, result)
+	}
+	strs, err := LocalAddresses()
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if want := []string{"127.0.0.1", "10.0.0.5", "fe80::1%eth0", "10.0.0.6"}; !reflect.DeepEqual(strs, want) {
+		t.Errorf("expected: %v got: %v", want, strs)
+	}
+
+	netInterfaces = func() ([]net.Interface, error) {
+		return nil, errors.New("enumeration failed")
+	}
+	if _, err := LocalAddrsTyped(); err == nil {
+		t.Errorf("expected interface enumeration error")
+	}
+	if _, err := LocalAddresses(); err == nil {
+		t.Errorf("expected interface enumeration error")
+	}
+}
+
+func TestDetectDefaultFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifaces   map[string][]net.Addr
+		expected IPFamily
+	}{
+		{
+			name: "ipv4 only",
+			ifaces: map[string][]net.Addr{
+				"lo":   {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+				"eth0": {ipNet("10.0.0.5/24"), ipNet("fe80::1/64")},
+			},
+			expected: V4,
+		},
+		{
+			name: "ipv6 only",
+			ifaces: map[string][]net.Addr{
+				"lo":   {ipNet("127.0.0.1/8")},
+				"eth0": {ipNet("fd00::5/64"), ipNet("fe80::1/64")},
+			},
+			expected: V6,
+		},
+		{
+			name: "dual stack",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("10.0.0.5/24"), ipNet("2001:db8::5/64")},
+			},
+			expected: Mixed,
+		},
+		{
+			name: "loopback only",
+			ifaces: map[string][]net.Addr{
+				"lo": {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+			},
+			expected: Unknown,
+		},
+		{
+			name: "down interfaces are ignored",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("10.0.0.5/24")},
+				"eth1": {ipNet("2001:db8::5/64")},
+			},
+			expected: V4,
+		},
+	}
+	for _, tt := range tests {
+		fakeInterfaces(t, tt.ifaces)
+		setInterfacesDown("eth1")
+		if result := detectDefaultFamily(); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestDefaultFamilyCaching(t *testing.T) {
+	defaultFamily = Unknown
+	t.Cleanup(func() {
+		defaultFamily = Unknown
+	})
+
+	fakeInterfaces(t, nil)
+	netInterfaces = func() ([]net.Interface, error) {
+		return nil, errors.New("enumeration failed")
+	}
+	if result := DefaultFamily(); result != Unknown {
+		t.Errorf("expected %v on enumeration failure, got %v", Unknown, result)
+	}
+
+	fakeInterfaces(t, map[string][]net.Addr{
+		"lo": {ipNet("127.0.0.1/8")},
+	})
+	if result := DefaultFamily(); result != Unknown {
+		t.Errorf("expected %v before interfaces are configured, got %v", Unknown, result)
+	}
+
+	fakeInterfaces(t, map[string][]net.Addr{
+		"eth0": {ipNet("10.0.0.5/24")},
+	})
+	if result := DefaultFamily(); result != V4 {
+		t.Errorf("expected %v once interfaces are configured, got %v", V4, result)
+	}
+
+	fakeInterfaces(t, map[string][]net.Addr{
+		"eth0": {ipNet("2001:db8::5/64")},
+	})
+	if result := DefaultFamily(); result != V4 {
+		t.Errorf("expected the cached %v, got %v", V4, result)
+	}
+}
+
+func TestParseInet6AddrFlags(t *testing.T) {
+	input := `00000000000000000000000000000001 01 80 10 80       lo
+20010db8000000000000000000000005 02 40 00 01     eth0
+fe800000000000000000000000000001 02 40 20 80     eth0
+malformed line
+`
+	flags, err := parseInet6AddrFlags(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	expected := map[ifaceAddr]uint32{
+		{iface: "lo", addr: netip.MustParseAddr("::1")}:           0x80,
+		{iface: "eth0", addr: netip.MustParseAddr("2001:db8::5")}: 0x01,
+		{iface: "eth0", addr: netip.MustParseAddr("fe80::1")}:     0x80,
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected: %v got: %v", expected, flags)
+	}
+}
+
+func TestMostStableLocalAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifaces   map[string][]net.Addr
+		down     []string
+		flags    map[ifaceAddr]uint32
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "permanent ipv6 over temporary",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("2001:db8::a1b2/64"), ipNet("2001:db8::5/64")},
+			},
+			flags: map[ifaceAddr]uint32{
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::a1b2")}: ifaFlagTemporary,
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::5")}:    0x80,
+			},
+			expected: "2001:db8::5",
+		},
+		{
+			name: "temporary over deprecated",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("2001:db8::1/64"), ipNet("2001:db8::2/64")},
+			},
+			flags: map[ifaceAddr]uint32{
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::1")}: ifaFlagDeprecated,
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::2")}: ifaFlagTemporary,
+			},
+			expected: "2001:db8::2",
+		},
+		{
+			name: "ipv4 is permanent",
+			ifaces: map[string][]net.Addr{
+				"lo":   {ipNet("127.0.0.1/8")},
+				"eth0": {ipNet("2001:db8::1/64"), ipNet("10.0.0.5/24")},
+			},
+			flags: map[ifaceAddr]uint32{
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::1")}: ifaFlagTemporary,
+			},
+			expected: "10.0.0.5",
+		},
+		{
+			name: "first permanent address wins",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("10.0.0.5/24")},
+				"eth1": {ipNet("10.0.1.5/24")},
+			},
+			expected: "10.0.0.5",
+		},
+		{
+			name: "flags are per interface",
+			ifaces: map[string][]net.Addr{
+				"eth0": {ipNet("2001:db8::1/64")},
+				"eth1": {ipNet("2001:db8::2/64")},
+			},
+			flags: map[ifaceAddr]uint32{
+				{iface: "eth1", addr: netip.MustParseAddr("2001:db8::1")}: ifaFlagTemporary,
+				{iface: "eth0", addr: netip.MustParseAddr("2001:db8::1")}: ifaFlagDeprecated,
+			},
+			expected: "2001:db8::2",
+		},
+		{
+			name: "link-local fallback",
+			ifaces: map[string][]net.Addr{
+				"lo":   {ipNet("127.0.0.1/8")},
+				"eth0": {ipNet("fe80::1/64")},
+			},
+			expected: "fe80::1%eth0",
+		},
+		{
+			name: "loopback fallback",
+			ifaces: map[string][]net.Addr{
+				"lo": {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+			},
+			expected: "127.0.0.1",
+		},
+		{
+			name: "down interfaces are skipped",
+			ifaces: map[string][]net.Addr{
+				"lo":   {ipNet("127.0.0.1/8")},
+				"eth0": {ipNet("10.0.0.5/24")},
+				"eth1": {ipNet("10.0.1.5/24")},
+			},
+			down:     []string{"eth0"},
+			expected: "10.0.1.5",
+		},
+		{
+			name:    "no addresses",
+			ifaces:  map[string][]net.Addr{"eth0": nil},
+			wantErr: true,
+		},
+		{
+			name:    "only down interfaces",
+			ifaces:  map[string][]net.Addr{"eth0": {ipNet("10.0.0.5/24")}},
+			down:    []string{"eth0"},
+			wantErr: true,
+		},
+	}
+	oldFlags := inet6AddrFlags
+	t.Cleanup(func() {
+		inet6AddrFlags = oldFlags
+	})
+	for _, tt := range tests {
+		fakeInterfaces(t, tt.ifaces)
+		setInterfacesDown(tt.down...)
+		flags := tt.flags
+		inet6AddrFlags = func() (map[ifaceAddr]uint32, error) {
+			return flags, nil
+		}
+		result, err := MostStableLocalAddress()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestInLocalSubnet(t *testing.T) {
+	fakeInterfaces(t, map[string][]net.Addr{
+		"lo":   {ipNet("127.0.0.1/8"), ipNet("::1/128")},
+		"eth0": {ipNet("10.0.0.5/24"), ipNet("fe80::1/64"), ipNet("2001:db8:1::5/64")},
+		"eth1": {&net.IPAddr{IP: net.ParseIP("192.168.1.5")}},
+		"docker0": {&net.IPNet{
+			IP:   net.ParseIP("172.17.0.1"),
+			Mask: net.CIDRMask(112, 128),
+		}},
+	})
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	oldPrefixes := localPrefixes
+	localPrefixes = &prefixCache{ttl: localPrefixTTL, now: clock.Now}
+	t.Cleanup(func() {
+		localPrefixes = oldPrefixes
+	})
+
+	tests := []struct {
+		ip       string
+		expected bool
+		wantErr  bool
+	}{
+		{ip: "10.0.0.200", expected: true},
+		{ip: "::ffff:10.0.0.200", expected: true},
+		{ip: "10.0.1.1", expected: false},
+		{ip: "127.1.2.3", expected: true},
+		{ip: "192.168.1.5", expected: true},
+		{ip: "192.168.1.6", expected: false},
+		{ip: "172.17.200.1", expected: true},
+		{ip: "172.18.0.1", expected: false},
+		{ip: "2001:db8:1::abcd", expected: true},
+		{ip: "2001:db8:2::1", expected: false},
+		{ip: "fe80::1234%eth1", expected: true},
+		{ip: "invalidip", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := InLocalSubnet(tt.ip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.ip, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+
+	// Prefixes are cached until the TTL expires.
+	calls := 0
+	netInterfaces = func() ([]net.Interface, error) {
+		calls++
+		return nil, errors.New("enumeration failed")
+	}
+	if ok, err := InLocalSubnet("10.0.0.200"); err != nil || !ok {
+		t.Errorf("expected cached prefixes to be used, got %t %v", ok, err)
+	}
+	clock.t = clock.t.Add(localPrefixTTL)
+	if _, err := InLocalSubnet("10.0.0.200"); err == nil {
+		t.Errorf("expected interface enumeration error after the cache expired")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 interface enumeration, got %d", calls)
+	}
+}
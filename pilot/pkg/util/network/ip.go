@@ -17,11 +17,16 @@ package network
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/netip"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
+
 	"istio.io/istio/pkg/sleep"
 	"istio.io/pkg/log"
 )
@@ -30,9 +35,13 @@ import (
 const (
 	waitInterval = 100 * time.Millisecond
 	waitTimeout  = 2 * time.Minute
+
+	// resolveTimeout bounds a single hostname lookup.
+	resolveTimeout = 15 * time.Second
 )
 
-type lookupIPAddrType = func(ctx context.Context, addr string) ([]netip.Addr, error)
+// LookupIPAddrType is the signature of functions used to resolve a host to its IP addresses.
+type LookupIPAddrType = func(ctx context.Context, addr string) ([]netip.Addr, error)
 
 // ErrResolveNoAddress error occurs when IP address resolution is attempted,
 // but no address was provided.
@@ -105,6 +114,17 @@ func getPrivateIPsIfAvailable() ([]string, bool) {
 	return ipAddresses, ok
 }
 
+// ResolveAddrOptions configures ResolveAddrWithOptions.
+type ResolveAddrOptions struct {
+	// Lookup overrides the function used to resolve hostnames. If nil,
+	// net.DefaultResolver is used.
+	Lookup LookupIPAddrType
+	// Shuffle selects a random address from the resolved set rather than
+	// always the first one, spreading load across multiple A/AAAA records.
+	// IPv4 addresses are still preferred over IPv6 addresses.
+	Shuffle bool
+}
+
 // ResolveAddr resolves an authority address to an IP address. Incoming
 // addr can be an IP address or hostname. If addr is an IPv6 address, the IP
 // part must be enclosed in square brackets.
@@ -112,11 +132,22 @@ func getPrivateIPsIfAvailable() ([]string, bool) {
 // LookupIPAddr() may return multiple IP addresses, of which this function returns
 // the first IPv4 entry. To use this function in an IPv6 only environment, either
 // provide an IPv6 address or ensure the hostname resolves to only IPv6 addresses.
-func ResolveAddr(addr string, lookupIPAddr ...lookupIPAddrType) (string, error) {
+func ResolveAddr(addr string, lookupIPAddr ...LookupIPAddrType) (string, error) {
+	opts := ResolveAddrOptions{}
+	if len(lookupIPAddr) > 0 {
+		// if there are more than one lookup function, ignore all but first
+		opts.Lookup = lookupIPAddr[0]
+	}
+	return ResolveAddrWithOptions(addr, opts)
+}
+
+// ResolveAddrWithOptions is like ResolveAddr, but allows customizing how the
+// resolved address is selected.
+func ResolveAddrWithOptions(addr string, opts ResolveAddrOptions) (string, error) {
 	if addr == "" {
 		return "", ErrResolveNoAddress
 	}
-	host, port, err := net.SplitHostPort(addr)
+	host, port, err := SplitHostPort(addr)
 	if err != nil {
 		return "", err
 	}
@@ -124,61 +155,423 @@ func ResolveAddr(addr string, lookupIPAddr ...lookupIPAddrType) (string, error)
 	log.Infof("Attempting to lookup address: %s", host)
 	defer log.Infof("Finished lookup of address: %s", host)
 	// lookup the udp address with a timeout of 15 seconds.
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
 	defer cancel()
-	var addrs []netip.Addr
-	var lookupErr error
-	if (len(lookupIPAddr) > 0) && (lookupIPAddr[0] != nil) {
-		// if there are more than one lookup function, ignore all but first
-		addrs, lookupErr = lookupIPAddr[0](ctx, host)
-	} else {
-		addrs, lookupErr = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
-	}
+	addrs, lookupErr := lookupHost(ctx, host, opts.Lookup)
 	if lookupErr != nil || len(addrs) == 0 {
 		return "", fmt.Errorf("lookup failed for IP address: %w", lookupErr)
 	}
-	var resolvedAddr string
+	if opts.Shuffle {
+		// Copy before shuffling so we never reorder a slice owned by the lookup function.
+		addrs = append([]netip.Addr(nil), addrs...)
+		// Use a per-call source to avoid contending on the global rand lock.
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+	}
+	resolvedAddr := selectAddr(addrs, port)
+	log.Infof("Addr resolved to: %s", resolvedAddr)
+	return resolvedAddr, nil
+}
+
+// lookupHost resolves host with lookup, or net.DefaultResolver if lookup is nil.
+func lookupHost(ctx context.Context, host string, lookup LookupIPAddrType) ([]netip.Addr, error) {
+	if lookup != nil {
+		return lookup(ctx, host)
+	}
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// resolveHost returns the addresses for host, which may be an IP literal or a
+// hostname resolved with lookup. IPv4-mapped IPv6 addresses are unwrapped.
+func resolveHost(host string, lookup LookupIPAddrType) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip.Unmap()}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("lookup failed for IP address: %w", err)
+	}
+	return unmapAddrs(addrs), nil
+}
+
+// splitHostPort splits addr into its host and numeric port.
+func splitHostPort(addr string) (string, uint16, error) {
+	if addr == "" {
+		return "", 0, ErrResolveNoAddress
+	}
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	pPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+	return host, uint16(pPort), nil
+}
+
+// ResolveSplit resolves addr, like ResolveAddr, but returns every resolved
+// address joined with the port, split into IPv4 and IPv6 results. Each list
+// is sorted. ErrResolveNoAddress is returned if nothing resolved.
+func ResolveSplit(addr string, lookup LookupIPAddrType) (v4 []string, v6 []string, err error) {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host, lookup)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lookup failed for IP address: %w", err)
+	}
+	for _, a := range sortedAddrs(unmapAddrs(addrs)) {
+		if !a.IsValid() {
+			continue
+		}
+		ap := netip.AddrPortFrom(a, port).String()
+		if a.Is4() {
+			v4 = append(v4, ap)
+		} else {
+			v6 = append(v6, ap)
+		}
+	}
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil, nil, ErrResolveNoAddress
+	}
+	return v4, v6, nil
+}
+
+// ResolveEndpoints resolves addr, like ResolveAddr, but returns an endpoint
+// for every resolved address, each with the port of addr. The endpoints are
+// sorted. ErrResolveNoAddress is returned if nothing resolved.
+func ResolveEndpoints(addr string, lookup LookupIPAddrType) ([]netip.AddrPort, error) {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := resolveHost(host, lookup)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]netip.AddrPort, 0, len(addrs))
+	for _, a := range sortedAddrs(addrs) {
+		if a.IsValid() {
+			endpoints = append(endpoints, netip.AddrPortFrom(a, port))
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrResolveNoAddress
+	}
+	return endpoints, nil
+}
+
+// NormalizeEndpoints resolves each host:port or ip:port entry of entries with
+// ResolveEndpoints and returns all resulting endpoints, in entry order and
+// sorted within each entry. The returned error slice has the same length as
+// entries, holding the error for each entry that failed or nil, so that
+// config loaders can report every problem at once.
+func NormalizeEndpoints(entries []string, lookup LookupIPAddrType) ([]netip.AddrPort, []error) {
+	var endpoints []netip.AddrPort
+	errs := make([]error, len(entries))
+	for i, entry := range entries {
+		resolved, err := ResolveEndpoints(entry, lookup)
+		if err != nil {
+			errs[i] = fmt.Errorf("invalid endpoint %q: %w", entry, err)
+			continue
+		}
+		endpoints = append(endpoints, resolved...)
+	}
+	return endpoints, errs
+}
+
+// ResolveAddrClamped resolves addr like ResolveEndpoints, returning between
+// min and max endpoints: an error is returned if fewer than min resolve, and
+// the sorted endpoints are truncated to max if more do, so that the same
+// subset is chosen every time.
+func ResolveAddrClamped(addr string, lookup LookupIPAddrType, min, max int) ([]string, error) {
+	if min < 0 || max < 1 || min > max {
+		return nil, fmt.Errorf("invalid address count bounds [%d, %d]", min, max)
+	}
+	endpoints, err := ResolveEndpoints(addr, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) < min {
+		return nil, fmt.Errorf("%s resolved to %d addresses, expected at least %d", addr, len(endpoints), min)
+	}
+	if len(endpoints) > max {
+		log.Warnf("%s resolved to %d addresses, truncating to %d", addr, len(endpoints), max)
+		endpoints = endpoints[:max]
+	}
+	out := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		out = append(out, ep.String())
+	}
+	return out, nil
+}
+
+// AssertResolvesStably resolves the host of addr iterations times and returns
+// an error if the resolved address set ever differs from the first result,
+// which indicates nondeterministic DNS, e.g. flaky resolution in CI. The error
+// includes both differing results. Lookup failures are returned as is.
+func AssertResolvesStably(addr string, lookup LookupIPAddrType, iterations int) error {
+	if iterations < 1 {
+		return fmt.Errorf("iterations must be positive, got %d", iterations)
+	}
+	host, _, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	var first []string
+	for i := 0; i < iterations; i++ {
+		addrs, err := resolveHost(host, lookup)
+		if err != nil {
+			return err
+		}
+		got := addrsToStrings(sortedAddrs(addrs))
+		if i == 0 {
+			first = got
+			continue
+		}
+		if !IPSetsEqual(first, got) {
+			return fmt.Errorf("resolution of %s is unstable: iteration 1 returned %v, iteration %d returned %v", addr, first, i+1, got)
+		}
+	}
+	return nil
+}
+
+// ToEnvoyLbAddress resolves addr, like ResolveAddr, and returns the
+// components of an Envoy socket address: the IP, the port and whether the IP
+// is IPv6. ErrResolveNoAddress is returned if addr is empty or nothing
+// resolved, and ErrInvalidPort if the port is 0, which Envoy cannot dial.
+func ToEnvoyLbAddress(addr string, lookup LookupIPAddrType) (ip string, port uint32, ipv6 bool, err error) {
+	host, p, err := splitHostPort(addr)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if p == 0 {
+		return "", 0, false, fmt.Errorf("address %s: %w: port 0 cannot be dialed", addr, ErrInvalidPort)
+	}
+	addrs, err := resolveHost(host, lookup)
+	if err != nil {
+		return "", 0, false, err
+	}
+	resolved, ok := preferredAddr(addrs)
+	if !ok {
+		return "", 0, false, ErrResolveNoAddress
+	}
+	return resolved.String(), uint32(p), resolved.Is6(), nil
+}
+
+// unmapAddrs returns a copy of addrs with IPv4-mapped IPv6 addresses unwrapped.
+func unmapAddrs(addrs []netip.Addr) []netip.Addr {
+	out := make([]netip.Addr, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.Unmap())
+	}
+	return out
+}
+
+// ResolveAddrOrLiteral resolves addr like ResolveAddr, but if resolution
+// fails it returns fallbackIP joined with the port of addr instead, e.g. to
+// keep a last-known-good address reachable during a DNS outage. fallbackIP is
+// validated up front, so an invalid fallback is an error even if resolution
+// would have succeeded.
+func ResolveAddrOrLiteral(addr string, lookup LookupIPAddrType, fallbackIP string) (string, error) {
+	fallback, err := netip.ParseAddr(fallbackIP)
+	if err != nil {
+		return "", fmt.Errorf("invalid fallback IP address: %v", err)
+	}
+	_, port, err := splitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := ResolveAddr(addr, lookup)
+	if err == nil && resolved != "" {
+		return resolved, nil
+	}
+	log.Warnf("Failed to resolve %s, falling back to %s: %v", addr, fallbackIP, err)
+	return netip.AddrPortFrom(fallback.Unmap(), port).String(), nil
+}
+
+// selectAddr joins port with the first IPv4 address in addrs, or the last
+// valid address if there are no IPv4 addresses. An empty string is returned
+// if none of the addresses are valid or the port cannot be parsed.
+func selectAddr(addrs []netip.Addr, port string) string {
+	pPort, pErr := strconv.ParseUint(port, 10, 16)
+	if pErr != nil {
+		return ""
+	}
+	addr, ok := preferredAddr(addrs)
+	if !ok {
+		return ""
+	}
+	return netip.AddrPortFrom(addr, uint16(pPort)).String()
+}
 
+// preferredAddr returns the first IPv4 address in addrs, or the last valid
+// address if there are no IPv4 addresses. IPv4-mapped IPv6 addresses are unwrapped.
+func preferredAddr(addrs []netip.Addr) (netip.Addr, bool) {
+	var resolved netip.Addr
 	for _, addr := range addrs {
 		// unwrap the IPv4-mapped IPv6 address
 		unwrapAddr := addr.Unmap()
 		if !unwrapAddr.IsValid() {
 			continue
 		}
-		pPort, pErr := strconv.ParseUint(port, 10, 16)
-		if pErr != nil {
-			continue
-		}
-		tmpAddPort := netip.AddrPortFrom(unwrapAddr, uint16(pPort))
-		resolvedAddr = tmpAddPort.String()
+		resolved = unwrapAddr
 		if unwrapAddr.Is4() {
 			break
 		}
 	}
-	log.Infof("Addr resolved to: %s", resolvedAddr)
-	return resolvedAddr, nil
+	return resolved, resolved.IsValid()
 }
 
-// AllIPv6 checks the addresses slice and returns true if all addresses
-// are valid IPv6 address, for all other cases it returns false.
-func AllIPv6(ipAddrs []string) bool {
-	for i := 0; i < len(ipAddrs); i++ {
-		addr, err := netip.ParseAddr(ipAddrs[i])
-		if err != nil {
-			// Should not happen, invalid IP in proxy's IPAddresses slice should have been caught earlier,
-			// skip it to prevent a panic.
-			continue
+// ResolveAddrStamped resolves addr like ResolveAddr, returning the typed
+// result along with the time it was obtained. When lookup is backed by
+// NewCachingLookup the time is when the result was inserted into the cache,
+// so callers can judge staleness; otherwise it is the time of the lookup.
+func ResolveAddrStamped(ctx context.Context, addr string, lookup LookupIPAddrType) (netip.AddrPort, time.Time, error) {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, time.Time{}, err
+	}
+	ctx, stamp := withResolvedAt(ctx)
+	addrs, err := lookupHost(ctx, host, lookup)
+	if err != nil {
+		return netip.AddrPort{}, time.Time{}, fmt.Errorf("lookup failed for IP address: %w", err)
+	}
+	resolved, ok := preferredAddr(addrs)
+	if !ok {
+		return netip.AddrPort{}, time.Time{}, ErrResolveNoAddress
+	}
+	if stamp.IsZero() {
+		*stamp = time.Now()
+	}
+	return netip.AddrPortFrom(resolved, port), *stamp, nil
+}
+
+// ResolveAddrTimed resolves addr like ResolveAddr, retrying failed lookups up
+// to attempts times in total with an exponential backoff in between. Each
+// attempt is bounded by its own perAttempt timeout derived from ctx, so that
+// a single slow attempt cannot consume the whole budget of ctx. If every
+// attempt fails, the returned error lists each attempt's error and duration.
+// An error is returned without any lookup if attempts or perAttempt is not
+// positive.
+func ResolveAddrTimed(ctx context.Context, addr string, lookup LookupIPAddrType, perAttempt time.Duration, attempts int) (string, error) {
+	if attempts < 1 {
+		return "", fmt.Errorf("attempts must be positive, got %d", attempts)
+	}
+	if perAttempt <= 0 {
+		return "", fmt.Errorf("per-attempt timeout must be positive, got %v", perAttempt)
+	}
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return netip.AddrPortFrom(ip.Unmap(), port).String(), nil
+	}
+	var errs *multierror.Error
+	backoff := waitInterval
+	for attempt := 1; attempt <= attempts; attempt++ {
+		start := time.Now()
+		resolved, err := resolveAttempt(ctx, host, lookup, perAttempt)
+		if err == nil {
+			return netip.AddrPortFrom(resolved, port).String(), nil
+		}
+		errs = multierror.Append(errs, fmt.Errorf("attempt %d failed after %v: %w", attempt, time.Since(start), err))
+		if attempt == attempts {
+			break
 		}
-		if addr.Is4() {
-			return false
+		if !sleep.UntilContext(ctx, backoff) {
+			errs = multierror.Append(errs, ctx.Err())
+			break
 		}
+		backoff *= 2
 	}
-	return true
+	return "", fmt.Errorf("failed to resolve %s: %w", addr, errs)
+}
+
+// resolveAttempt looks up host once, bounded by timeout, and returns its preferred address.
+func resolveAttempt(ctx context.Context, host string, lookup LookupIPAddrType, timeout time.Duration) (netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host, lookup)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resolved, ok := preferredAddr(addrs)
+	if !ok {
+		return netip.Addr{}, ErrResolveNoAddress
+	}
+	return resolved, nil
+}
+
+// ResolveAddrCachedOnly resolves addr like ResolveAddr, but only using
+// results already present in cache; the network is never consulted. The
+// returned boolean reports whether a result was found. IP literals are
+// always resolved; for hostnames a nil cache is treated as a miss.
+func ResolveAddrCachedOnly(addr string, cache *Cache) (string, bool) {
+	host, port, err := SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else {
+		if cache == nil {
+			return "", false
+		}
+		var ok bool
+		if addrs, ok = cache.Get(host); !ok {
+			return "", false
+		}
+	}
+	resolvedAddr := selectAddr(addrs, port)
+	return resolvedAddr, resolvedAddr != ""
+}
+
+// AllIPv6 checks the addresses slice and returns true if all addresses
+// are valid IPv6 address, for all other cases it returns false.
+func AllIPv6(ipAddrs []string) bool {
+	return !anyParsedAddr(ipAddrs, notIPv6)
+}
+
+// AllIPv6Addrs is the netip.Addr equivalent of AllIPv6, for callers that
+// already hold parsed addresses.
+func AllIPv6Addrs(addrs []netip.Addr) bool {
+	return !anyAddr(addrs, notIPv6)
 }
 
 // AllIPv4 checks the addresses slice and returns true if all addresses
 // are valid IPv4 address, for all other cases it returns false.
 func AllIPv4(ipAddrs []string) bool {
+	return !anyParsedAddr(ipAddrs, notIPv4)
+}
+
+// AllIPv4Addrs is the netip.Addr equivalent of AllIPv4, for callers that
+// already hold parsed addresses.
+func AllIPv4Addrs(addrs []netip.Addr) bool {
+	return !anyAddr(addrs, notIPv4)
+}
+
+func notIPv6(addr netip.Addr) bool {
+	return addr.Is4()
+}
+
+func notIPv4(addr netip.Addr) bool {
+	return !addr.Is4() && addr.Is6()
+}
+
+// anyParsedAddr returns true if pred holds for any of the addresses slice.
+// Each entry is parsed in turn, so that no intermediate slice is allocated.
+func anyParsedAddr(ipAddrs []string, pred func(netip.Addr) bool) bool {
 	for i := 0; i < len(ipAddrs); i++ {
 		addr, err := netip.ParseAddr(ipAddrs[i])
 		if err != nil {
@@ -186,11 +579,21 @@ func AllIPv4(ipAddrs []string) bool {
 			// skip it to prevent a panic.
 			continue
 		}
-		if !addr.Is4() && addr.Is6() {
-			return false
+		if pred(addr) {
+			return true
 		}
 	}
-	return true
+	return false
+}
+
+// anyAddr returns true if pred holds for any valid address of addrs.
+func anyAddr(addrs []netip.Addr, pred func(netip.Addr) bool) bool {
+	for _, addr := range addrs {
+		if addr.IsValid() && pred(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 // GlobalUnicastIP returns the first global unicast address in the passed in addresses.
@@ -208,3 +611,325 @@ func GlobalUnicastIP(ipAddrs []string) string {
 	}
 	return ""
 }
+
+// WouldSelectGlobalUnicast returns true if GlobalUnicastIP(ipAddrs) selects
+// candidate. Both are compared in their normalized form.
+func WouldSelectGlobalUnicast(ipAddrs []string, candidate string) bool {
+	addr, err := netip.ParseAddr(candidate)
+	if err != nil {
+		return false
+	}
+	selected := GlobalUnicastIP(ipAddrs)
+	return selected != "" && selected == addr.String()
+}
+
+// ReverseDNSName returns the fully qualified in-addr.arpa or ip6.arpa name
+// used for PTR lookups of the given IP address. IPv4-mapped IPv6 addresses
+// are treated as IPv4.
+func ReverseDNSName(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	addr = addr.Unmap()
+	var b strings.Builder
+	if addr.Is4() {
+		octets := addr.As4()
+		for i := len(octets) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(octets[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String(), nil
+	}
+	const hexDigit = "0123456789abcdef"
+	octets := addr.As16()
+	for i := len(octets) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigit[octets[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigit[octets[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
+
+// UnmapIPs rewrites, in place, every IPv4-mapped IPv6 address in addrs
+// (e.g. ::ffff:1.2.3.4) to its plain IPv4 form and returns addrs. Other
+// entries, including invalid ones, are left untouched and order is preserved.
+func UnmapIPs(addrs []string) []string {
+	for i, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is4In6() {
+			continue
+		}
+		addrs[i] = addr.Unmap().String()
+	}
+	return addrs
+}
+
+// CompareAddrStrings compares two IP addresses numerically, returning -1, 0
+// or +1 in the style of slices.SortFunc comparators. IPv4 addresses sort
+// before IPv6 addresses. Unparseable addresses sort after all valid ones and
+// are ordered lexically amongst themselves.
+func CompareAddrStrings(a, b string) int {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	default:
+		return addrA.Compare(addrB)
+	}
+}
+
+// SortIPs sorts ips in place according to CompareAddrStrings.
+func SortIPs(ips []string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		return CompareAddrStrings(ips[i], ips[j]) < 0
+	})
+}
+
+// StripZone removes the zone identifier (e.g. %eth0) from an IPv6 address.
+// IPv4 addresses, addresses without a zone and invalid input are returned unchanged.
+func StripZone(ip string) string {
+	if !HasZone(ip) {
+		return ip
+	}
+	addr, _, _ := strings.Cut(ip, "%")
+	return addr
+}
+
+// HasZone returns true if ip is an IPv6 address with a zone identifier.
+func HasZone(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && addr.Zone() != ""
+}
+
+// DedupeIPs returns a copy of ips with duplicate entries removed, keeping the
+// first occurrence of each entry.
+func DedupeIPs(ips []string) []string {
+	seen := make(map[string]struct{}, len(ips))
+	out := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if _, f := seen[ip]; f {
+			continue
+		}
+		seen[ip] = struct{}{}
+		out = append(out, ip)
+	}
+	return out
+}
+
+// FindMappedDuplicates returns the pairs of entries in ips that are the same
+// IPv4 address written natively and as an IPv4-mapped IPv6 address, e.g.
+// 1.2.3.4 and ::ffff:1.2.3.4. Each pair holds the native entry first and is
+// listed in the order of the mapped entries in ips. Invalid entries are ignored.
+func FindMappedDuplicates(ips []string) [][2]string {
+	native := map[netip.Addr]string{}
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Is4() {
+			continue
+		}
+		if _, f := native[addr]; !f {
+			native[addr] = ip
+		}
+	}
+	var pairs [][2]string
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Is4In6() {
+			continue
+		}
+		if n, f := native[addr.Unmap()]; f {
+			pairs = append(pairs, [2]string{n, ip})
+		}
+	}
+	return pairs
+}
+
+// CanonicalizeAddrs returns the canonical form of addrs, ready for hashing or
+// comparison: invalid entries are dropped, addresses are normalized to their
+// standard text form with IPv4-mapped IPv6 addresses unmapped, duplicates are
+// removed, and the result is sorted numerically with IPv4 before IPv6. addrs
+// is not modified.
+func CanonicalizeAddrs(addrs []string) []string {
+	normalized := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if addr, err := netip.ParseAddr(a); err == nil {
+			normalized = append(normalized, addr.String())
+		}
+	}
+	out := DedupeIPs(UnmapIPs(normalized))
+	SortIPs(out)
+	return out
+}
+
+// MergeResolved merges two maps of host to resolved addresses. Hosts present
+// in primary take primary's addresses, other hosts take secondary's, e.g. to
+// overlay static overrides on DNS results. Each merged address list is
+// deduplicated. Neither input is modified.
+func MergeResolved(primary, secondary map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(primary)+len(secondary))
+	for host, addrs := range secondary {
+		out[host] = DedupeIPs(addrs)
+	}
+	for host, addrs := range primary {
+		out[host] = DedupeIPs(addrs)
+	}
+	return out
+}
+
+// IPSetDelta returns the addresses present in newIPs but not oldIPs (added)
+// and those present in oldIPs but not newIPs (removed). Addresses are
+// normalized, with IPv4-mapped IPv6 addresses unwrapped, before comparison
+// and invalid entries are ignored. Both results are sorted.
+func IPSetDelta(oldIPs, newIPs []string) (added, removed []string) {
+	oldSet, newSet := normalizedIPSet(oldIPs), normalizedIPSet(newIPs)
+	for ip := range newSet {
+		if _, f := oldSet[ip]; !f {
+			added = append(added, ip)
+		}
+	}
+	for ip := range oldSet {
+		if _, f := newSet[ip]; !f {
+			removed = append(removed, ip)
+		}
+	}
+	SortIPs(added)
+	SortIPs(removed)
+	return added, removed
+}
+
+// IPSetsEqual returns true if a and b contain the same set of addresses,
+// ignoring order, duplicates and invalid entries. IPv4-mapped IPv6 addresses
+// are considered equal to their IPv4 form.
+func IPSetsEqual(a, b []string) bool {
+	added, removed := IPSetDelta(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// normalizedIPSet returns the set of valid, unmapped addresses in ips.
+func normalizedIPSet(ips []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(ips))
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		set[addr.Unmap().String()] = struct{}{}
+	}
+	return set
+}
+
+// MinIP returns the numerically smallest address in ips. An error is returned
+// if ips is empty, contains an invalid address or mixes IPv4 and IPv6.
+func MinIP(ips []string) (string, error) {
+	return extremeIP(ips, -1)
+}
+
+// MaxIP returns the numerically largest address in ips. An error is returned
+// if ips is empty, contains an invalid address or mixes IPv4 and IPv6.
+func MaxIP(ips []string) (string, error) {
+	return extremeIP(ips, 1)
+}
+
+// extremeIP returns the smallest (want is -1) or largest (want is 1) address in ips.
+func extremeIP(ips []string, want int) (string, error) {
+	if len(ips) == 0 {
+		return "", ErrResolveNoAddress
+	}
+	var best netip.Addr
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return "", err
+		}
+		if !best.IsValid() {
+			best = addr
+			continue
+		}
+		if addr.Is4() != best.Is4() {
+			return "", fmt.Errorf("cannot compare %s and %s: IP families differ", best, addr)
+		}
+		if addr.Compare(best) == want {
+			best = addr
+		}
+	}
+	return best.String(), nil
+}
+
+// AddrsEqual returns true if a and b are the same IP address, treating an
+// IPv4-mapped IPv6 address as equal to its IPv4 form, so ::ffff:1.2.3.4
+// equals 1.2.3.4. It returns false if either address is invalid.
+func AddrsEqual(a, b string) bool {
+	addrA, err := netip.ParseAddr(a)
+	if err != nil {
+		return false
+	}
+	addrB, err := netip.ParseAddr(b)
+	if err != nil {
+		return false
+	}
+	return addrA.Unmap() == addrB.Unmap()
+}
+
+// ExcludingSelf returns the entries of ipAddrs that are not one of selfAddrs,
+// preserving order. Addresses are compared in normalized form, so IPv4-mapped
+// IPv6 addresses match their IPv4 form. This keeps the local node out of
+// endpoint lists, e.g. to prevent gateway self-routing loops.
+func ExcludingSelf(ipAddrs []string, selfAddrs []string) []string {
+	self := normalizedIPSet(selfAddrs)
+	out := make([]string, 0, len(ipAddrs))
+	for _, ip := range ipAddrs {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			if _, f := self[addr.Unmap().String()]; f {
+				continue
+			}
+		}
+		out = append(out, ip)
+	}
+	return out
+}
+
+// anonymizedInvalidIP is returned by AnonymizeIP for input that is not an IP address.
+const anonymizedInvalidIP = "invalid"
+
+// AnonymizeOptions configures how many leading bits AnonymizeIPWithOptions keeps.
+type AnonymizeOptions struct {
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// DefaultAnonymizeOptions keeps the /24 of IPv4 addresses and the /48 of IPv6 addresses.
+var DefaultAnonymizeOptions = AnonymizeOptions{IPv4PrefixLen: 24, IPv6PrefixLen: 48}
+
+// AnonymizeIP zeroes the host bits of ip using DefaultAnonymizeOptions, so
+// logs can retain subnet-level information without full addresses. For
+// example 10.1.2.3 becomes 10.1.2.0. Invalid input returns "invalid".
+func AnonymizeIP(ip string) string {
+	return AnonymizeIPWithOptions(ip, DefaultAnonymizeOptions)
+}
+
+// AnonymizeIPWithOptions is like AnonymizeIP with configurable prefix lengths.
+func AnonymizeIPWithOptions(ip string, opts AnonymizeOptions) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return anonymizedInvalidIP
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := opts.IPv6PrefixLen
+	if addr.Is4() {
+		bits = opts.IPv4PrefixLen
+	}
+	p, err := addr.Prefix(bits)
+	if err != nil {
+		return anonymizedInvalidIP
+	}
+	return p.Addr().String()
+}
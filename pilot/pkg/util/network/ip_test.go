@@ -16,11 +16,14 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // The test may run on a system with localhost = 127.0.0.1 or ::1, so we
@@ -127,6 +130,20 @@ func TestResolveAddr(t *testing.T) {
 			errStr:   "address 2001:db8::20:9080: too many colons in address",
 			lookup:   nil,
 		},
+		{
+			name:     "IPv6 missing closing bracket",
+			input:    "[::1:9080",
+			expected: "",
+			errStr:   "address [::1:9080: mismatched brackets in address",
+			lookup:   nil,
+		},
+		{
+			name:     "IPv6 stray closing bracket",
+			input:    "::1]:9080",
+			expected: "",
+			errStr:   "address ::1]:9080: mismatched brackets in address",
+			lookup:   nil,
+		},
 		{
 			name:     "Colon, but no port",
 			input:    "localhost:",
@@ -285,3 +302,1116 @@ func TestGlobalUnicastIP(t *testing.T) {
 		}
 	}
 }
+
+func TestAllIPv4AndIPv6Addrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []netip.Addr
+		allV4 bool
+		allV6 bool
+	}{
+		{
+			name:  "ipv4 only",
+			addrs: []netip.Addr{netip.MustParseAddr("1.1.1.1"), netip.MustParseAddr("127.0.0.1")},
+			allV4: true,
+			allV6: false,
+		},
+		{
+			name:  "ipv6 only",
+			addrs: []netip.Addr{netip.MustParseAddr("1111:2222::1"), netip.MustParseAddr("::1")},
+			allV4: false,
+			allV6: true,
+		},
+		{
+			name:  "mixed ipv4 and ipv6",
+			addrs: []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")},
+			allV4: false,
+			allV6: false,
+		},
+		{
+			name:  "invalid addr is skipped",
+			addrs: []netip.Addr{{}},
+			allV4: true,
+			allV6: true,
+		},
+	}
+	for _, tt := range tests {
+		if got := AllIPv4Addrs(tt.addrs); got != tt.allV4 {
+			t.Errorf("Test %s failed, AllIPv4Addrs expected: %t got: %t", tt.name, tt.allV4, got)
+		}
+		if got := AllIPv6Addrs(tt.addrs); got != tt.allV6 {
+			t.Errorf("Test %s failed, AllIPv6Addrs expected: %t got: %t", tt.name, tt.allV6, got)
+		}
+	}
+}
+
+func TestWouldSelectGlobalUnicast(t *testing.T) {
+	tests := []struct {
+		name      string
+		addrs     []string
+		candidate string
+		expected  bool
+	}{
+		{
+			name:      "selected address",
+			addrs:     []string{"127.0.0.1", "1.1.1.1", "2.2.2.2"},
+			candidate: "1.1.1.1",
+			expected:  true,
+		},
+		{
+			name:      "later global unicast address",
+			addrs:     []string{"127.0.0.1", "1.1.1.1", "2.2.2.2"},
+			candidate: "2.2.2.2",
+			expected:  false,
+		},
+		{
+			name:      "candidate is normalized",
+			addrs:     []string{"fe80::1", "2001:db8::1"},
+			candidate: "2001:0db8:0::1",
+			expected:  true,
+		},
+		{
+			name:      "no global unicast address",
+			addrs:     []string{"127.0.0.1"},
+			candidate: "127.0.0.1",
+			expected:  false,
+		},
+		{
+			name:      "test for invalid ip address",
+			addrs:     []string{"1.1.1.1"},
+			candidate: "invalidip",
+			expected:  false,
+		},
+	}
+	for _, tt := range tests {
+		if result := WouldSelectGlobalUnicast(tt.addrs, tt.candidate); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %t got: %t", tt.name, tt.expected, result)
+		}
+	}
+}
+
+var benchAddrs = []string{"1.1.1.1", "127.0.0.1", "2.2.2.2", "10.0.0.1", "192.168.1.1"}
+
+func BenchmarkAllIPv4(b *testing.B) {
+	b.Run("strings", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			AllIPv4(benchAddrs)
+		}
+	})
+	b.Run("addrs", func(b *testing.B) {
+		addrs := make([]netip.Addr, 0, len(benchAddrs))
+		for _, a := range benchAddrs {
+			addrs = append(addrs, netip.MustParseAddr(a))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			AllIPv4Addrs(addrs)
+		}
+	})
+}
+
+func TestReverseDNSName(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "ipv4",
+			ip:       "1.2.3.4",
+			expected: "4.3.2.1.in-addr.arpa.",
+		},
+		{
+			name:     "ipv4-mapped ipv6",
+			ip:       "::ffff:10.0.0.1",
+			expected: "1.0.0.10.in-addr.arpa.",
+		},
+		{
+			name:     "ipv6",
+			ip:       "2001:db8::567:89ab",
+			expected: "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		},
+		{
+			name:    "invalid ip address",
+			ip:      "invalidip",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := ReverseDNSName(tt.ip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveAddrWithOptionsShuffle(t *testing.T) {
+	lookup := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		return []netip.Addr{
+			netip.MustParseAddr("2001:db8::68"),
+			netip.MustParseAddr("1.2.3.4"),
+			netip.MustParseAddr("1.2.3.5"),
+			netip.MustParseAddr("1.2.3.6"),
+		}, nil
+	}
+	valid := map[string]bool{"1.2.3.4:9080": true, "1.2.3.5:9080": true, "1.2.3.6:9080": true}
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		actual, err := ResolveAddrWithOptions("www.foo.com:9080", ResolveAddrOptions{Lookup: lookup, Shuffle: true})
+		if err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+		if !valid[actual] {
+			t.Fatalf("expected one of the IPv4 addresses, got %q", actual)
+		}
+		seen[actual] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected shuffle to select more than one address, got %v", seen)
+	}
+
+	// Without shuffle the first IPv4 address is always selected.
+	for i := 0; i < 10; i++ {
+		actual, err := ResolveAddrWithOptions("www.foo.com:9080", ResolveAddrOptions{Lookup: lookup})
+		if err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+		if actual != "1.2.3.4:9080" {
+			t.Fatalf("expected address %q, got %q", "1.2.3.4:9080", actual)
+		}
+	}
+}
+
+func TestUnmapIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected []string
+	}{
+		{
+			name:     "mapped addresses are unmapped",
+			addrs:    []string{"::ffff:1.2.3.4", "2001:db8::1", "::ffff:10.0.0.1"},
+			expected: []string{"1.2.3.4", "2001:db8::1", "10.0.0.1"},
+		},
+		{
+			name:     "plain addresses are untouched",
+			addrs:    []string{"1.2.3.4", "::1"},
+			expected: []string{"1.2.3.4", "::1"},
+		},
+		{
+			name:     "invalid entries pass through",
+			addrs:    []string{"invalidip", "::ffff:1.2.3.4"},
+			expected: []string{"invalidip", "1.2.3.4"},
+		},
+		{
+			name:     "test for empty value",
+			addrs:    []string{},
+			expected: []string{},
+		},
+	}
+	for _, tt := range tests {
+		result := UnmapIPs(tt.addrs)
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		lookup func(ctx context.Context, addr string) ([]netip.Addr, error)
+		v4     []string
+		v6     []string
+		err    error
+	}{
+		{
+			name:   "Host by name - mixed",
+			input:  "www.foo.com:9080",
+			lookup: MockLookupIPAddr,
+			v4:     []string{"1.2.3.4:9080", "1.2.3.5:9080"},
+			v6:     []string{"[2001:db8::68]:9080"},
+		},
+		{
+			name:   "Host by name - IPv6 only",
+			input:  "www.foo.com:9080",
+			lookup: MockLookupIPAddrIPv6,
+			v6:     []string{"[2001:db8::68]:9080"},
+		},
+		{
+			name:   "Host by name - sorted and unmapped",
+			input:  "www.foo.com:80",
+			lookup: staticLookup("::ffff:10.0.0.2", "10.0.0.1", "2001:db8::2", "2001:db8::1"),
+			v4:     []string{"10.0.0.1:80", "10.0.0.2:80"},
+			v6:     []string{"[2001:db8::1]:80", "[2001:db8::2]:80"},
+		},
+		{
+			name:   "No addresses",
+			input:  "www.foo.com:80",
+			lookup: staticLookup(),
+			err:    ErrResolveNoAddress,
+		},
+		{
+			name:  "Empty host",
+			input: "",
+			err:   ErrResolveNoAddress,
+		},
+	}
+	for _, tt := range tests {
+		v4, v6, err := ResolveSplit(tt.input, tt.lookup)
+		if err != tt.err {
+			t.Errorf("[%s] expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if !reflect.DeepEqual(v4, tt.v4) || !reflect.DeepEqual(v6, tt.v6) {
+			t.Errorf("[%s] expected %v %v, got %v %v", tt.name, tt.v4, tt.v6, v4, v6)
+		}
+	}
+}
+
+func TestCompareAddrStrings(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "1.2.3.4", b: "1.2.3.4", expected: 0},
+		{a: "1.2.3.4", b: "1.2.3.5", expected: -1},
+		{a: "10.0.0.1", b: "9.0.0.1", expected: 1},
+		{a: "255.255.255.255", b: "::", expected: -1},
+		{a: "2001:db8::2", b: "2001:db8::10", expected: -1},
+		{a: "invalidip", b: "2001:db8::1", expected: 1},
+		{a: "1.2.3.4", b: "invalidip", expected: -1},
+		{a: "invalid-a", b: "invalid-b", expected: -1},
+		{a: "invalid-a", b: "invalid-a", expected: 0},
+	}
+	for _, tt := range tests {
+		if result := CompareAddrStrings(tt.a, tt.b); result != tt.expected {
+			t.Errorf("CompareAddrStrings(%q, %q) expected: %d got: %d", tt.a, tt.b, tt.expected, result)
+		}
+		if result := CompareAddrStrings(tt.b, tt.a); result != -tt.expected {
+			t.Errorf("CompareAddrStrings(%q, %q) expected: %d got: %d", tt.b, tt.a, -tt.expected, result)
+		}
+	}
+}
+
+func TestSortIPs(t *testing.T) {
+	ips := []string{"invalidip", "2001:db8::10", "10.0.0.1", "2001:db8::2", "9.0.0.1", "bad"}
+	SortIPs(ips)
+	expected := []string{"9.0.0.1", "10.0.0.1", "2001:db8::2", "2001:db8::10", "bad", "invalidip"}
+	if !reflect.DeepEqual(ips, expected) {
+		t.Errorf("expected: %v got: %v", expected, ips)
+	}
+}
+
+func TestStripZone(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+		hasZone  bool
+	}{
+		{ip: "fe80::1%eth0", expected: "fe80::1", hasZone: true},
+		{ip: "fe80:0:0::1%1", expected: "fe80:0:0::1", hasZone: true},
+		{ip: "fe80::1", expected: "fe80::1"},
+		{ip: "1.2.3.4", expected: "1.2.3.4"},
+		{ip: "www.foo.com", expected: "www.foo.com"},
+		{ip: "invalid%zone", expected: "invalid%zone"},
+	}
+	for _, tt := range tests {
+		if result := StripZone(tt.ip); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+		if result := HasZone(tt.ip); result != tt.hasZone {
+			t.Errorf("Test %s failed, HasZone expected: %t got: %t", tt.ip, tt.hasZone, result)
+		}
+	}
+}
+
+func TestDedupeIPs(t *testing.T) {
+	ips := []string{"1.2.3.4", "::1", "1.2.3.4", "invalidip", "::1"}
+	expected := []string{"1.2.3.4", "::1", "invalidip"}
+	if result := DedupeIPs(ips); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected: %v got: %v", expected, result)
+	}
+}
+
+func TestFindMappedDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		ips      []string
+		expected [][2]string
+	}{
+		{
+			name:     "mapped and native",
+			ips:      []string{"::ffff:1.2.3.4", "5.6.7.8", "1.2.3.4", "::FFFF:5.6.7.8", "::ffff:9.9.9.9"},
+			expected: [][2]string{{"1.2.3.4", "::ffff:1.2.3.4"}, {"5.6.7.8", "::FFFF:5.6.7.8"}},
+		},
+		{
+			name:     "repeated entries",
+			ips:      []string{"1.2.3.4", "1.2.3.4", "::ffff:1.2.3.4", "::ffff:1.2.3.4"},
+			expected: [][2]string{{"1.2.3.4", "::ffff:1.2.3.4"}, {"1.2.3.4", "::ffff:1.2.3.4"}},
+		},
+		{
+			name:     "no duplicates",
+			ips:      []string{"1.2.3.4", "::1.2.3.4", "2001:db8::1", "invalidip"},
+			expected: nil,
+		},
+		{
+			name:     "test for empty value",
+			ips:      nil,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		if result := FindMappedDuplicates(tt.ips); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestCanonicalizeAddrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		expected []string
+	}{
+		{
+			name: "mixed input",
+			addrs: []string{
+				"2001:0db8::1", "10.0.0.10", "::ffff:10.0.0.2", "invalidip", "10.0.0.2", "2001:db8::1", "fe80::1%eth0", "10.0.0.9",
+			},
+			expected: []string{"10.0.0.2", "10.0.0.9", "10.0.0.10", "2001:db8::1", "fe80::1%eth0"},
+		},
+		{
+			name:     "only invalid",
+			addrs:    []string{"invalidip", ""},
+			expected: []string{},
+		},
+		{
+			name:     "test for empty value",
+			addrs:    nil,
+			expected: []string{},
+		},
+	}
+	for _, tt := range tests {
+		input := append([]string(nil), tt.addrs...)
+		result := CanonicalizeAddrs(tt.addrs)
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+		if !reflect.DeepEqual(tt.addrs, input) {
+			t.Errorf("Test %s failed, expected input to be left untouched, got: %v", tt.name, tt.addrs)
+		}
+	}
+	if !IPSetsEqual(CanonicalizeAddrs([]string{"::ffff:1.2.3.4", "2001:db8::1"}), []string{"2001:db8::1", "1.2.3.4"}) {
+		t.Errorf("expected canonical addresses to compare equal")
+	}
+}
+
+func TestMergeResolved(t *testing.T) {
+	primary := map[string][]string{
+		"a.com": {"1.1.1.1", "1.1.1.1"},
+		"b.com": {"2.2.2.2"},
+	}
+	secondary := map[string][]string{
+		"b.com": {"9.9.9.9"},
+		"c.com": {"3.3.3.3", "::3", "3.3.3.3"},
+	}
+	expected := map[string][]string{
+		"a.com": {"1.1.1.1"},
+		"b.com": {"2.2.2.2"},
+		"c.com": {"3.3.3.3", "::3"},
+	}
+	result := MergeResolved(primary, secondary)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected: %v got: %v", expected, result)
+	}
+	if len(primary["a.com"]) != 2 || len(secondary["c.com"]) != 3 {
+		t.Errorf("expected inputs to be left untouched, got %v %v", primary, secondary)
+	}
+}
+
+func TestIPSetDelta(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     []string
+		new     []string
+		added   []string
+		removed []string
+	}{
+		{
+			name:    "added and removed",
+			old:     []string{"1.1.1.1", "2.2.2.2", "2001:db8::1"},
+			new:     []string{"2001:db8::2", "10.0.0.1", "2.2.2.2", "3.3.3.3"},
+			added:   []string{"3.3.3.3", "10.0.0.1", "2001:db8::2"},
+			removed: []string{"1.1.1.1", "2001:db8::1"},
+		},
+		{
+			name: "equivalent forms are unchanged",
+			old:  []string{"::ffff:1.1.1.1", "2001:0db8::1", "1.1.1.1"},
+			new:  []string{"2001:db8::1", "1.1.1.1"},
+		},
+		{
+			name:  "invalid entries are ignored",
+			old:   []string{"invalidip"},
+			new:   []string{"1.1.1.1", "bad"},
+			added: []string{"1.1.1.1"},
+		},
+	}
+	for _, tt := range tests {
+		added, removed := IPSetDelta(tt.old, tt.new)
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("Test %s failed, expected: %v %v got: %v %v", tt.name, tt.added, tt.removed, added, removed)
+		}
+		equal := len(tt.added) == 0 && len(tt.removed) == 0
+		if result := IPSetsEqual(tt.old, tt.new); result != equal {
+			t.Errorf("Test %s failed, IPSetsEqual expected: %t got: %t", tt.name, equal, result)
+		}
+	}
+}
+
+func TestMinMaxIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		addrs   []string
+		min     string
+		max     string
+		wantErr bool
+	}{
+		{
+			name:  "ipv4",
+			addrs: []string{"10.0.0.10", "10.0.0.9", "9.255.255.255", "10.0.1.0"},
+			min:   "9.255.255.255",
+			max:   "10.0.1.0",
+		},
+		{
+			name:  "ipv6",
+			addrs: []string{"2001:db8::10", "2001:db8::9", "2001:db8::a"},
+			min:   "2001:db8::9",
+			max:   "2001:db8::10",
+		},
+		{
+			name:  "single address",
+			addrs: []string{"1.2.3.4"},
+			min:   "1.2.3.4",
+			max:   "1.2.3.4",
+		},
+		{
+			name:    "test for empty value",
+			addrs:   []string{},
+			wantErr: true,
+		},
+		{
+			name:    "mixed ipv4 and ipv6",
+			addrs:   []string{"1.2.3.4", "::1"},
+			wantErr: true,
+		},
+		{
+			name:    "test for invalid ip address",
+			addrs:   []string{"1.2.3.4", "invalidip"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		minIP, err := MinIP(tt.addrs)
+		if (err != nil) != tt.wantErr || minIP != tt.min {
+			t.Errorf("Test %s failed, MinIP expected: %q (error %t) got: %q, %v", tt.name, tt.min, tt.wantErr, minIP, err)
+		}
+		maxIP, err := MaxIP(tt.addrs)
+		if (err != nil) != tt.wantErr || maxIP != tt.max {
+			t.Errorf("Test %s failed, MaxIP expected: %q (error %t) got: %q, %v", tt.name, tt.max, tt.wantErr, maxIP, err)
+		}
+	}
+}
+
+func TestAddrsEqual(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{a: "1.2.3.4", b: "1.2.3.4", expected: true},
+		{a: "::ffff:1.2.3.4", b: "1.2.3.4", expected: true},
+		{a: "1.2.3.4", b: "::ffff:1.2.3.4", expected: true},
+		{a: "2001:db8::1", b: "2001:0db8:0::1", expected: true},
+		{a: "1.2.3.4", b: "1.2.3.5", expected: false},
+		{a: "fe80::1%eth0", b: "fe80::1", expected: false},
+		{a: "invalidip", b: "invalidip", expected: false},
+		{a: "1.2.3.4", b: "invalidip", expected: false},
+	}
+	for _, tt := range tests {
+		if result := AddrsEqual(tt.a, tt.b); result != tt.expected {
+			t.Errorf("AddrsEqual(%q, %q) expected: %t got: %t", tt.a, tt.b, tt.expected, result)
+		}
+	}
+}
+
+func TestExcludingSelf(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		self     []string
+		expected []string
+	}{
+		{
+			name:     "self addresses are removed",
+			addrs:    []string{"10.0.0.3", "10.0.0.1", "2001:db8::1", "10.0.0.2"},
+			self:     []string{"10.0.0.1", "2001:db8::1"},
+			expected: []string{"10.0.0.3", "10.0.0.2"},
+		},
+		{
+			name:     "mapped and normalized forms match",
+			addrs:    []string{"::ffff:10.0.0.1", "2001:0db8::1", "10.0.0.2"},
+			self:     []string{"10.0.0.1", "2001:db8:0::1"},
+			expected: []string{"10.0.0.2"},
+		},
+		{
+			name:     "invalid entries are kept",
+			addrs:    []string{"invalidip", "10.0.0.1"},
+			self:     []string{"10.0.0.1", "invalidip"},
+			expected: []string{"invalidip"},
+		},
+		{
+			name:     "no self addresses",
+			addrs:    []string{"10.0.0.1"},
+			self:     nil,
+			expected: []string{"10.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		if result := ExcludingSelf(tt.addrs, tt.self); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		lookup   func(ctx context.Context, addr string) ([]netip.Addr, error)
+		expected []string
+		err      error
+		wantErr  bool
+	}{
+		{
+			name:     "Host by name",
+			input:    "db.internal:5432",
+			lookup:   MockLookupIPAddr,
+			expected: []string{"1.2.3.4:5432", "1.2.3.5:5432", "[2001:db8::68]:5432"},
+		},
+		{
+			name:     "Host by IP",
+			input:    "[::ffff:1.2.3.4]:5432",
+			lookup:   staticLookup(),
+			expected: []string{"1.2.3.4:5432"},
+		},
+		{
+			name:   "No addresses",
+			input:  "db.internal:5432",
+			lookup: staticLookup(),
+			err:    ErrResolveNoAddress,
+		},
+		{
+			name:  "Empty host",
+			input: "",
+			err:   ErrResolveNoAddress,
+		},
+		{
+			name:    "Missing port",
+			input:   "db.internal",
+			lookup:  MockLookupIPAddr,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		endpoints, err := ResolveEndpoints(tt.input, tt.lookup)
+		if tt.err != nil && err != tt.err {
+			t.Errorf("[%s] expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if tt.err == nil && (err != nil) != tt.wantErr {
+			t.Errorf("[%s] expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		var result []string
+		for _, ep := range endpoints {
+			result = append(result, ep.String())
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("[%s] expected %v, got %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestNormalizeEndpoints(t *testing.T) {
+	lookup := func(ctx context.Context, host string) ([]netip.Addr, error) {
+		if host == "db.internal" {
+			return MockLookupIPAddr(ctx, host)
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+	entries := []string{
+		"db.internal:5432",
+		"[::ffff:10.0.0.1]:80",
+		"missing.internal:80",
+		"10.0.0.2",
+		"[2001:db8::1]:443",
+	}
+	endpoints, errs := NormalizeEndpoints(entries, lookup)
+	var result []string
+	for _, ep := range endpoints {
+		result = append(result, ep.String())
+	}
+	expected := []string{"1.2.3.4:5432", "1.2.3.5:5432", "[2001:db8::68]:5432", "10.0.0.1:80", "[2001:db8::1]:443"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	if len(errs) != len(entries) {
+		t.Fatalf("expected %d errors, got %d", len(entries), len(errs))
+	}
+	for i, err := range errs {
+		wantErr := i == 2 || i == 3
+		if (err != nil) != wantErr {
+			t.Errorf("entry %q: expected error: %t got: %v", entries[i], wantErr, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), entries[i]) {
+			t.Errorf("entry %q: expected error to name the entry, got: %v", entries[i], err)
+		}
+	}
+
+	endpoints, errs = NormalizeEndpoints(nil, lookup)
+	if len(endpoints) != 0 || len(errs) != 0 {
+		t.Errorf("expected no results for empty input, got %v %v", endpoints, errs)
+	}
+}
+
+func TestResolveAddrClamped(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		lookup   func(ctx context.Context, addr string) ([]netip.Addr, error)
+		min, max int
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "within bounds",
+			input:    "db.internal:5432",
+			lookup:   MockLookupIPAddr,
+			min:      1,
+			max:      3,
+			expected: []string{"1.2.3.4:5432", "1.2.3.5:5432", "[2001:db8::68]:5432"},
+		},
+		{
+			name:     "truncated to the sorted prefix",
+			input:    "db.internal:5432",
+			lookup:   staticLookup("2001:db8::68", "1.2.3.5", "1.2.3.4"),
+			min:      1,
+			max:      2,
+			expected: []string{"1.2.3.4:5432", "1.2.3.5:5432"},
+		},
+		{
+			name:    "too few",
+			input:   "db.internal:5432",
+			lookup:  MockLookupIPAddrIPv6,
+			min:     2,
+			max:     3,
+			wantErr: true,
+		},
+		{
+			name:    "invalid bounds",
+			input:   "db.internal:5432",
+			lookup:  MockLookupIPAddr,
+			min:     3,
+			max:     2,
+			wantErr: true,
+		},
+		{
+			name:    "no addresses",
+			input:   "db.internal:5432",
+			lookup:  staticLookup(),
+			min:     0,
+			max:     2,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := ResolveAddrClamped(tt.input, tt.lookup, tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("[%s] expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("[%s] expected %v, got %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestAssertResolvesStably(t *testing.T) {
+	calls := 0
+	flipping := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		calls++
+		if calls%2 == 0 {
+			return []netip.Addr{netip.MustParseAddr("1.2.3.5")}, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	reordering := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		calls++
+		if calls%2 == 0 {
+			return []netip.Addr{netip.MustParseAddr("1.2.3.5"), netip.MustParseAddr("1.2.3.4")}, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("::ffff:1.2.3.4"), netip.MustParseAddr("1.2.3.5")}, nil
+	}
+	failing := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		return nil, fmt.Errorf("server failure")
+	}
+	tests := []struct {
+		name       string
+		input      string
+		lookup     func(ctx context.Context, addr string) ([]netip.Addr, error)
+		iterations int
+		errStr     string
+	}{
+		{
+			name:       "stable",
+			input:      "db.internal:5432",
+			lookup:     MockLookupIPAddr,
+			iterations: 5,
+		},
+		{
+			name:       "reordered results are stable",
+			input:      "db.internal:5432",
+			lookup:     reordering,
+			iterations: 5,
+		},
+		{
+			name:       "unstable",
+			input:      "db.internal:5432",
+			lookup:     flipping,
+			iterations: 5,
+			errStr:     "resolution of db.internal:5432 is unstable: iteration 1 returned [1.2.3.4], iteration 2 returned [1.2.3.5]",
+		},
+		{
+			name:       "single iteration",
+			input:      "db.internal:5432",
+			lookup:     flipping,
+			iterations: 1,
+		},
+		{
+			name:       "lookup failure",
+			input:      "db.internal:5432",
+			lookup:     failing,
+			iterations: 2,
+			errStr:     "lookup failed for IP address: server failure",
+		},
+		{
+			name:       "invalid iterations",
+			input:      "db.internal:5432",
+			lookup:     MockLookupIPAddr,
+			iterations: 0,
+			errStr:     "iterations must be positive, got 0",
+		},
+	}
+	for _, tt := range tests {
+		calls = 0
+		err := AssertResolvesStably(tt.input, tt.lookup, tt.iterations)
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != tt.errStr {
+			t.Errorf("[%s] expected error %q, got %q", tt.name, tt.errStr, errStr)
+		}
+	}
+}
+
+func TestToEnvoyLbAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		lookup  func(ctx context.Context, addr string) ([]netip.Addr, error)
+		ip      string
+		port    uint32
+		ipv6    bool
+		err     error
+		wantErr bool
+	}{
+		{
+			name:   "Host by name",
+			input:  "db.internal:5432",
+			lookup: MockLookupIPAddr,
+			ip:     "1.2.3.4",
+			port:   5432,
+		},
+		{
+			name:   "Host by name IPv6",
+			input:  "db.internal:5432",
+			lookup: MockLookupIPAddrIPv6,
+			ip:     "2001:db8::68",
+			port:   5432,
+			ipv6:   true,
+		},
+		{
+			name:   "Mapped IP literal",
+			input:  "[::ffff:1.2.3.4]:80",
+			lookup: staticLookup(),
+			ip:     "1.2.3.4",
+			port:   80,
+		},
+		{
+			name:   "IPv6 literal",
+			input:  "[2001:db8::1]:443",
+			lookup: staticLookup(),
+			ip:     "2001:db8::1",
+			port:   443,
+			ipv6:   true,
+		},
+		{
+			name:   "No addresses",
+			input:  "db.internal:5432",
+			lookup: staticLookup(),
+			err:    ErrResolveNoAddress,
+		},
+		{
+			name:  "Empty host",
+			input: "",
+			err:   ErrResolveNoAddress,
+		},
+		{
+			name:    "Port out of range",
+			input:   "1.2.3.4:65536",
+			wantErr: true,
+		},
+		{
+			name:   "Port zero",
+			input:  "1.2.3.4:0",
+			lookup: staticLookup(),
+			err:    ErrInvalidPort,
+		},
+		{
+			name:    "Missing port",
+			input:   "db.internal",
+			lookup:  MockLookupIPAddr,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		ip, port, ipv6, err := ToEnvoyLbAddress(tt.input, tt.lookup)
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("[%s] expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if tt.err == nil && (err != nil) != tt.wantErr {
+			t.Errorf("[%s] expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if ip != tt.ip || port != tt.port || ipv6 != tt.ipv6 {
+			t.Errorf("[%s] expected %s %d %t, got %s %d %t", tt.name, tt.ip, tt.port, tt.ipv6, ip, port, ipv6)
+		}
+	}
+}
+
+func TestResolveAddrOrLiteral(t *testing.T) {
+	failingLookup := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		return nil, fmt.Errorf("server failure")
+	}
+	tests := []struct {
+		name     string
+		input    string
+		lookup   func(ctx context.Context, addr string) ([]netip.Addr, error)
+		fallback string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "resolution succeeds",
+			input:    "www.foo.com:9080",
+			lookup:   MockLookupIPAddr,
+			fallback: "10.0.0.1",
+			expected: "1.2.3.4:9080",
+		},
+		{
+			name:     "resolution fails",
+			input:    "www.foo.com:9080",
+			lookup:   failingLookup,
+			fallback: "10.0.0.1",
+			expected: "10.0.0.1:9080",
+		},
+		{
+			name:     "ipv6 fallback",
+			input:    "www.foo.com:9080",
+			lookup:   failingLookup,
+			fallback: "2001:db8::1",
+			expected: "[2001:db8::1]:9080",
+		},
+		{
+			name:     "invalid fallback",
+			input:    "www.foo.com:9080",
+			lookup:   MockLookupIPAddr,
+			fallback: "invalidip",
+			wantErr:  true,
+		},
+		{
+			name:     "missing port",
+			input:    "www.foo.com",
+			lookup:   failingLookup,
+			fallback: "10.0.0.1",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := ResolveAddrOrLiteral(tt.input, tt.lookup, tt.fallback)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("[%s] expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("[%s] expected address %q, got %q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		opts     *AnonymizeOptions
+		expected string
+	}{
+		{ip: "10.1.2.3", expected: "10.1.2.0"},
+		{ip: "::ffff:10.1.2.3", expected: "10.1.2.0"},
+		{ip: "2001:db8:1234:5678::1", expected: "2001:db8:1234::"},
+		{ip: "fe80::1%eth0", expected: "fe80::"},
+		{ip: "10.1.2.3", opts: &AnonymizeOptions{IPv4PrefixLen: 16, IPv6PrefixLen: 64}, expected: "10.1.0.0"},
+		{ip: "2001:db8:1234:5678::1", opts: &AnonymizeOptions{IPv4PrefixLen: 16, IPv6PrefixLen: 64}, expected: "2001:db8:1234:5678::"},
+		{ip: "10.1.2.3", opts: &AnonymizeOptions{IPv4PrefixLen: 33}, expected: "invalid"},
+		{ip: "invalidip", expected: "invalid"},
+	}
+	for _, tt := range tests {
+		var result string
+		if tt.opts == nil {
+			result = AnonymizeIP(tt.ip)
+		} else {
+			result = AnonymizeIPWithOptions(tt.ip, *tt.opts)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.ip, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveAddrTimed(t *testing.T) {
+	var calls int
+	failFirst := func(n int) func(ctx context.Context, addr string) ([]netip.Addr, error) {
+		return func(context.Context, string) ([]netip.Addr, error) {
+			calls++
+			if calls <= n {
+				return nil, fmt.Errorf("server failure")
+			}
+			return []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("1.2.3.4")}, nil
+		}
+	}
+	slowFirst := func(ctx context.Context, _ string) ([]netip.Addr, error) {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	tests := []struct {
+		name     string
+		input    string
+		lookup   func(ctx context.Context, addr string) ([]netip.Addr, error)
+		attempts int
+		expected string
+		errStrs  []string
+	}{
+		{
+			name:     "succeeds after retries",
+			input:    "www.foo.com:80",
+			lookup:   failFirst(2),
+			attempts: 3,
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:     "slow attempt times out",
+			input:    "www.foo.com:80",
+			lookup:   slowFirst,
+			attempts: 2,
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:     "all attempts fail",
+			input:    "www.foo.com:80",
+			lookup:   failFirst(2),
+			attempts: 2,
+			errStrs:  []string{"failed to resolve www.foo.com:80", "attempt 1 failed after", "attempt 2 failed after", "server failure"},
+		},
+		{
+			name:     "no addresses",
+			input:    "www.foo.com:80",
+			lookup:   staticLookup(),
+			attempts: 1,
+			errStrs:  []string{"attempt 1 failed after", ErrResolveNoAddress.Error()},
+		},
+		{
+			name:     "ip literal",
+			input:    "[::ffff:1.2.3.4]:80",
+			lookup:   failFirst(10),
+			attempts: 1,
+			expected: "1.2.3.4:80",
+		},
+		{
+			name:     "invalid attempts",
+			input:    "www.foo.com:80",
+			lookup:   failFirst(0),
+			attempts: 0,
+			errStrs:  []string{"attempts must be positive"},
+		},
+	}
+	for _, tt := range tests {
+		calls = 0
+		result, err := ResolveAddrTimed(context.Background(), tt.input, tt.lookup, 20*time.Millisecond, tt.attempts)
+		if len(tt.errStrs) == 0 && err != nil {
+			t.Errorf("[%s] expected success, got: %v", tt.name, err)
+		}
+		for _, s := range tt.errStrs {
+			if err == nil || !strings.Contains(err.Error(), s) {
+				t.Errorf("[%s] expected error mentioning %q, got: %v", tt.name, s, err)
+			}
+		}
+		if result != tt.expected {
+			t.Errorf("[%s] expected %q, got %q", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveAddrTimedInvalidTimeout(t *testing.T) {
+	for _, perAttempt := range []time.Duration{0, -time.Second} {
+		calls := 0
+		lookup := func(context.Context, string) ([]netip.Addr, error) {
+			calls++
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+		}
+		_, err := ResolveAddrTimed(context.Background(), "www.foo.com:80", lookup, perAttempt, 3)
+		if err == nil || !strings.Contains(err.Error(), "per-attempt timeout must be positive") {
+			t.Errorf("[%v] expected invalid timeout error, got: %v", perAttempt, err)
+		}
+		if calls != 0 {
+			t.Errorf("[%v] expected no lookups, got %d", perAttempt, calls)
+		}
+	}
+}
+
+func TestResolveAddrTimedCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lookup := func(context.Context, string) ([]netip.Addr, error) {
+		cancel()
+		return nil, fmt.Errorf("server failure")
+	}
+	_, err := ResolveAddrTimed(ctx, "www.foo.com:80", lookup, time.Second, 5)
+	if err == nil || !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "attempt 2") {
+		t.Errorf("expected cancellation to stop retries, got: %v", err)
+	}
+}
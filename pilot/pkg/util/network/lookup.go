@@ -0,0 +1,408 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sync/singleflight"
+
+	"istio.io/pkg/log"
+)
+
+// NewBoundedLookup wraps delegate so that at most max addresses are returned
+// for a single host. This protects against misbehaving names returning an
+// excessive number of records. Results are sorted before truncation so the
+// same subset is returned for the same answer. Truncation is logged; use
+// NewBoundedLookupWithReporter to report it differently, or not at all. A max
+// below one means no limit, and delegate is returned unchanged.
+func NewBoundedLookup(delegate LookupIPAddrType, max int) LookupIPAddrType {
+	return NewBoundedLookupWithReporter(delegate, max, func(host string, count int) {
+		log.Warnf("lookup of %s returned %d addresses, truncating to %d", host, count, max)
+	})
+}
+
+// NewBoundedLookupWithReporter is like NewBoundedLookup, but truncation is
+// reported to onTruncate with the host and the number of addresses returned
+// by delegate. If onTruncate is nil truncation is not reported.
+func NewBoundedLookupWithReporter(delegate LookupIPAddrType, max int, onTruncate func(host string, count int)) LookupIPAddrType {
+	if max < 1 {
+		return delegate
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		if err != nil || len(addrs) <= max {
+			return addrs, err
+		}
+		if onTruncate != nil {
+			onTruncate(host, len(addrs))
+		}
+		return sortedAddrs(addrs)[:max], nil
+	}
+}
+
+// addrsToStrings returns the string form of each of addrs.
+func addrsToStrings(addrs []netip.Addr) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// sortedAddrs returns a sorted copy of addrs, leaving the input untouched.
+func sortedAddrs(addrs []netip.Addr) []netip.Addr {
+	out := append([]netip.Addr(nil), addrs...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Less(out[j])
+	})
+	return out
+}
+
+// NewLimitedLookup wraps delegate so that no more than maxConcurrent lookups
+// run at the same time across all callers of the returned function. Lookups
+// over the limit wait for a slot, giving up if their context is done first. A
+// maxConcurrent below one means no limit, and delegate is returned unchanged.
+func NewLimitedLookup(delegate LookupIPAddrType, maxConcurrent int) LookupIPAddrType {
+	if maxConcurrent < 1 {
+		return delegate
+	}
+	sem := make(chan struct{}, maxConcurrent)
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-sem }()
+		return delegate(ctx, host)
+	}
+}
+
+// maxCNAMEChain bounds the number of CNAME indirections followed by NewCNAMEFlatteningLookup.
+const maxCNAMEChain = 8
+
+// NewCNAMEFlatteningLookup wraps delegate so that CNAME indirection is followed
+// using cname before the final name is resolved. cname should return the
+// target of the alias, or the name itself (or an empty string) if it is not an
+// alias, as net.LookupCNAME does. Loops and chains longer than maxCNAMEChain
+// result in an error.
+func NewCNAMEFlatteningLookup(cname func(host string) (string, error), delegate LookupIPAddrType) LookupIPAddrType {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		current := normalizeDNSName(host)
+		seen := map[string]struct{}{current: {}}
+		for i := 0; ; i++ {
+			target, err := cname(current)
+			if err != nil {
+				return nil, fmt.Errorf("CNAME lookup failed for %s: %w", current, err)
+			}
+			target = normalizeDNSName(target)
+			if target == "" || target == current {
+				break
+			}
+			if _, f := seen[target]; f {
+				return nil, fmt.Errorf("CNAME loop detected resolving %s at %s", host, target)
+			}
+			if i == maxCNAMEChain {
+				return nil, fmt.Errorf("CNAME chain for %s exceeds %d entries", host, maxCNAMEChain)
+			}
+			seen[target] = struct{}{}
+			current = target
+		}
+		return delegate(ctx, current)
+	}
+}
+
+// normalizeDNSName lowercases name and strips a trailing dot.
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// AuditEntry records the outcome of a single lookup made through NewAuditingLookup.
+type AuditEntry struct {
+	Host    string
+	Results []netip.Addr
+	Err     error
+	At      time.Time
+}
+
+// NewAuditingLookup wraps delegate so that every lookup is reported to sink,
+// e.g. to keep a record of what DNS returned for a host. If sink is nil
+// delegate is returned unchanged.
+func NewAuditingLookup(delegate LookupIPAddrType, sink func(AuditEntry)) LookupIPAddrType {
+	if sink == nil {
+		return delegate
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		sink(AuditEntry{Host: host, Results: addrs, Err: err, At: time.Now()})
+		return addrs, err
+	}
+}
+
+// NewStickyLookup wraps delegate so that once a result is returned for a host,
+// the same result keeps being returned for at least minHold before delegate
+// is consulted again. This dampens DNS round-robin jitter that would
+// otherwise cause unnecessary pushes.
+func NewStickyLookup(delegate LookupIPAddrType, minHold time.Duration) LookupIPAddrType {
+	return NewStickyLookupWithHold(delegate, func(string) time.Duration { return minHold })
+}
+
+// NewStickyLookupWithHold is like NewStickyLookup, but the hold duration is
+// determined per host by hold.
+func NewStickyLookupWithHold(delegate LookupIPAddrType, hold func(host string) time.Duration) LookupIPAddrType {
+	return newStickyLookup(delegate, hold, time.Now)
+}
+
+type stickyResult struct {
+	addrs []netip.Addr
+	since time.Time
+}
+
+func newStickyLookup(delegate LookupIPAddrType, hold func(host string) time.Duration, now func() time.Time) LookupIPAddrType {
+	var mu sync.Mutex
+	held := map[string]stickyResult{}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		mu.Lock()
+		r, ok := held[host]
+		mu.Unlock()
+		if ok && now().Sub(r.since) < hold(host) {
+			return r.addrs, nil
+		}
+		addrs, err := delegate(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		held[host] = stickyResult{addrs: addrs, since: now()}
+		mu.Unlock()
+		return addrs, nil
+	}
+}
+
+// NewRotatingLookup wraps delegate so that successive lookups of the same host
+// rotate the order of the returned addresses by one position, spreading
+// first-address selection across a name's records. The set of addresses is
+// unchanged; only the order rotates.
+func NewRotatingLookup(delegate LookupIPAddrType) LookupIPAddrType {
+	var mu sync.Mutex
+	offsets := map[string]int{}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		if err != nil || len(addrs) < 2 {
+			return addrs, err
+		}
+		mu.Lock()
+		offset := offsets[host] % len(addrs)
+		offsets[host] = offset + 1
+		mu.Unlock()
+		rotated := make([]netip.Addr, 0, len(addrs))
+		rotated = append(rotated, addrs[offset:]...)
+		return append(rotated, addrs[:offset]...), nil
+	}
+}
+
+// NewMDNSAwareLookup routes lookups of .local names (matched case-insensitively)
+// to mdns and all other lookups to delegate. No mDNS implementation is
+// provided; callers inject one.
+func NewMDNSAwareLookup(mdns func(host string) ([]netip.Addr, error), delegate LookupIPAddrType) LookupIPAddrType {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		if strings.HasSuffix(normalizeDNSName(host), ".local") {
+			return mdns(host)
+		}
+		return delegate(ctx, host)
+	}
+}
+
+// TTLLookup is like LookupIPAddrType, but also returns how long the result may be cached.
+type TTLLookup = func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+
+// NewMaxTTLLookup wraps delegate so that returned TTLs never exceed maxTTL,
+// ensuring re-resolution happens at least that often regardless of the
+// upstream TTL. Zero or negative TTLs, from delegate or as maxTTL, are
+// reported as zero, meaning the result should be resolved every time.
+func NewMaxTTLLookup(delegate TTLLookup, maxTTL time.Duration) TTLLookup {
+	return func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		addrs, ttl, err := delegate(ctx, host)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		return addrs, ttl, nil
+	}
+}
+
+// NewCIDRFilterLookup wraps delegate so that only resolved addresses within
+// one of the allow CIDRs are returned, guarding against names that resolve to
+// unexpected, e.g. internal, addresses as in DNS rebinding attacks. If every
+// address is filtered out ErrResolveNoAddress is returned. An error is
+// returned if any of allow is malformed.
+func NewCIDRFilterLookup(delegate LookupIPAddrType, allow []string) (LookupIPAddrType, error) {
+	if err := ValidateCIDRs(allow); err != nil {
+		return nil, err
+	}
+	prefixes := make([]netip.Prefix, 0, len(allow))
+	for _, cidr := range allow {
+		prefixes = append(prefixes, unmapPrefix(netip.MustParsePrefix(cidr)))
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var allowed []netip.Addr
+		for _, a := range addrs {
+			candidate := a.Unmap().WithZone("")
+			for _, p := range prefixes {
+				if p.Contains(candidate) {
+					allowed = append(allowed, a)
+					break
+				}
+			}
+		}
+		if len(allowed) == 0 {
+			return nil, ErrResolveNoAddress
+		}
+		return allowed, nil
+	}, nil
+}
+
+// NewLocalFamilyPreferringLookup wraps delegate so that addresses of
+// localFamily, typically the family of the local node as returned by
+// DefaultFamily, are ordered ahead of the others; e.g. on an IPv6-only node
+// AAAA results come before A results. The relative order within each family
+// is kept and no address is dropped. For Mixed or Unknown results are
+// returned unchanged.
+func NewLocalFamilyPreferringLookup(delegate LookupIPAddrType, localFamily IPFamily) LookupIPAddrType {
+	if localFamily != V4 && localFamily != V6 {
+		return delegate
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		addrs, err := delegate(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ordered := make([]netip.Addr, 0, len(addrs))
+		var others []netip.Addr
+		for _, a := range addrs {
+			if familyOf(a) == localFamily {
+				ordered = append(ordered, a)
+			} else {
+				others = append(others, a)
+			}
+		}
+		return append(ordered, others...), nil
+	}
+}
+
+// TraceStarter starts a tracing span, returning a function that ends it with
+// the outcome of the traced operation. It allows integrating lookups with a
+// tracing library without this package depending on one.
+type TraceStarter interface {
+	StartSpan(name string) (end func(err error))
+}
+
+// NewTracedLookup wraps delegate so that each lookup is traced by a span
+// named after the host, ended with the lookup error, if any. If tracer is nil
+// delegate is returned unchanged.
+func NewTracedLookup(delegate LookupIPAddrType, tracer TraceStarter) LookupIPAddrType {
+	if tracer == nil {
+		return delegate
+	}
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		end := tracer.StartSpan(host)
+		addrs, err := delegate(ctx, host)
+		end(err)
+		return addrs, err
+	}
+}
+
+// NewSingleflightLookup wraps delegate so that, across all callers of the
+// returned function, at most one lookup per host is in flight at a time and
+// concurrent callers share its result, including errors. This collapses
+// identical queries, e.g. during heavy config reloads. The shared lookup runs
+// detached from the callers' contexts, bounded by the default resolution
+// timeout, so a caller giving up does not cancel it for the others. Each
+// returned function has its own set of in-flight lookups; use
+// NewGlobalSingleflightLookup to share them process-wide.
+func NewSingleflightLookup(delegate LookupIPAddrType) LookupIPAddrType {
+	return newSingleflightLookup(&singleflight.Group{}, delegate)
+}
+
+// globalLookups holds the in-flight lookups of NewGlobalSingleflightLookup,
+// with one group per delegate so that different delegates never share
+// results. Entries are never removed, which is fine for the handful of
+// long-lived delegates a process creates.
+var (
+	globalLookupsMu sync.Mutex
+	globalLookups   = map[uintptr]*globalLookup{}
+)
+
+type globalLookup struct {
+	// delegate keeps the closure alive, so its address is never reused as a key.
+	delegate LookupIPAddrType
+	group    *singleflight.Group
+}
+
+// NewGlobalSingleflightLookup is like NewSingleflightLookup, but in-flight
+// lookups are shared by every function returned for the same delegate in the
+// process, e.g. by components wrapping a common resolver independently.
+// Lookups through different delegates, including separate closures of the
+// same function, are never shared.
+func NewGlobalSingleflightLookup(delegate LookupIPAddrType) LookupIPAddrType {
+	// A func value points to its closure, which is distinct for every closure
+	// created, so it identifies the delegate.
+	key := *(*uintptr)(unsafe.Pointer(&delegate))
+	globalLookupsMu.Lock()
+	g, f := globalLookups[key]
+	if !f {
+		g = &globalLookup{delegate: delegate, group: &singleflight.Group{}}
+		globalLookups[key] = g
+	}
+	globalLookupsMu.Unlock()
+	return newSingleflightLookup(g.group, delegate)
+}
+
+func newSingleflightLookup(group *singleflight.Group, delegate LookupIPAddrType) LookupIPAddrType {
+	return func(ctx context.Context, host string) ([]netip.Addr, error) {
+		ch := group.DoChan(host, func() (interface{}, error) {
+			lookupCtx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			defer cancel()
+			return delegate(lookupCtx, host)
+		})
+		select {
+		case res := <-ch:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			// Copy so that callers cannot modify each other's results.
+			return append([]netip.Addr(nil), res.Val.([]netip.Addr)...), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
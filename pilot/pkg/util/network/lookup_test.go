@@ -0,0 +1,754 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func staticLookup(addrs ...string) LookupIPAddrType {
+	return func(_ context.Context, _ string) ([]netip.Addr, error) {
+		ret := make([]netip.Addr, 0, len(addrs))
+		for _, a := range addrs {
+			ret = append(ret, netip.MustParseAddr(a))
+		}
+		return ret, nil
+	}
+}
+
+func TestNewBoundedLookup(t *testing.T) {
+	errLookup := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		return nil, errors.New("lookup failed")
+	}
+	tests := []struct {
+		name     string
+		delegate LookupIPAddrType
+		max      int
+		expected []netip.Addr
+		wantErr  bool
+	}{
+		{
+			name:     "under the limit is returned as is",
+			delegate: staticLookup("1.2.3.5", "1.2.3.4"),
+			max:      3,
+			expected: []netip.Addr{netip.MustParseAddr("1.2.3.5"), netip.MustParseAddr("1.2.3.4")},
+		},
+		{
+			name:     "over the limit is sorted and truncated",
+			delegate: staticLookup("2001:db8::1", "1.2.3.6", "1.2.3.4", "1.2.3.5"),
+			max:      2,
+			expected: []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("1.2.3.5")},
+		},
+		{
+			name:     "errors are passed through",
+			delegate: errLookup,
+			max:      1,
+			wantErr:  true,
+		},
+		{
+			name:     "zero max is unlimited",
+			delegate: staticLookup("1.2.3.5", "1.2.3.4"),
+			max:      0,
+			expected: []netip.Addr{netip.MustParseAddr("1.2.3.5"), netip.MustParseAddr("1.2.3.4")},
+		},
+		{
+			name:     "negative max is unlimited",
+			delegate: staticLookup("1.2.3.5", "1.2.3.4"),
+			max:      -1,
+			expected: []netip.Addr{netip.MustParseAddr("1.2.3.5"), netip.MustParseAddr("1.2.3.4")},
+		},
+	}
+	for _, tt := range tests {
+		result, err := NewBoundedLookup(tt.delegate, tt.max)(context.Background(), "www.foo.com")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestNewBoundedLookupWithReporter(t *testing.T) {
+	var reported []string
+	lookup := NewBoundedLookupWithReporter(staticLookup("1.2.3.6", "1.2.3.4", "1.2.3.5"), 2, func(host string, count int) {
+		reported = append(reported, fmt.Sprintf("%s: %d", host, count))
+	})
+	if _, err := lookup(context.Background(), "www.foo.com"); err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if _, err := lookup(context.Background(), "www.bar.com"); err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if expected := []string{"www.foo.com: 3", "www.bar.com: 3"}; !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected truncation reports %v, got %v", expected, reported)
+	}
+
+	quiet := NewBoundedLookupWithReporter(staticLookup("1.2.3.6", "1.2.3.4", "1.2.3.5"), 2, nil)
+	expected := []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("1.2.3.5")}
+	if result, err := quiet(context.Background(), "www.foo.com"); err != nil || !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v without reporting, got %v %v", expected, result, err)
+	}
+}
+
+func TestNewLimitedLookup(t *testing.T) {
+	const limit, callers = 3, 20
+	var inflight, peak int32
+	entered := make(chan struct{}, callers)
+	release := make(chan struct{})
+	lookup := NewLimitedLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		entered <- struct{}{}
+		<-release
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}, limit)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := lookup(context.Background(), "www.foo.com"); err != nil {
+				t.Errorf("expected success, but saw error: %v", err)
+			}
+		}()
+	}
+	// Wait for the semaphore to fill up before letting lookups finish.
+	for i := 0; i < limit; i++ {
+		<-entered
+	}
+	if got := atomic.LoadInt32(&inflight); got != limit {
+		t.Errorf("expected %d lookups in flight, got %d", limit, got)
+	}
+	close(release)
+	wg.Wait()
+	if len(entered) != callers-limit {
+		t.Errorf("expected all %d lookups to run, got %d", callers, len(entered)+limit)
+	}
+	if peak > limit {
+		t.Errorf("expected at most %d concurrent lookups, got %d", limit, peak)
+	}
+}
+
+func TestNewLimitedLookupUnlimited(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		// Both lookups must run at once, otherwise the first never returns.
+		started, release := make(chan struct{}), make(chan struct{})
+		lookup := NewLimitedLookup(func(_ context.Context, host string) ([]netip.Addr, error) {
+			if host == "first" {
+				close(started)
+				<-release
+			} else {
+				close(release)
+			}
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+		}, limit)
+		done := make(chan error)
+		go func() {
+			_, err := lookup(context.Background(), "first")
+			done <- err
+		}()
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if _, err := lookup(ctx, "second"); err != nil {
+			t.Errorf("limit %d: expected no limit, but saw error: %v", limit, err)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("limit %d: expected success, but saw error: %v", limit, err)
+		}
+	}
+}
+
+func TestNewLimitedLookupCancel(t *testing.T) {
+	started := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+	lookup := NewLimitedLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		close(started)
+		<-block
+		return nil, nil
+	}, 1)
+	go func() {
+		_, _ = lookup(context.Background(), "www.foo.com")
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := lookup(ctx, "www.foo.com"); err != context.DeadlineExceeded {
+		t.Errorf("expected %v while waiting for a slot, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestNewCNAMEFlatteningLookup(t *testing.T) {
+	aliases := map[string]string{
+		"www.foo.com":  "cdn.foo.com.",
+		"cdn.foo.com":  "EDGE.cdn.net.",
+		"loop-a.com":   "loop-b.com",
+		"loop-b.com":   "loop-a.com",
+		"broken.com":   "",
+		"self.foo.com": "self.foo.com.",
+	}
+	for i := 0; i <= maxCNAMEChain; i++ {
+		aliases[fmt.Sprintf("chain%d.com", i)] = fmt.Sprintf("chain%d.com", i+1)
+	}
+	cname := func(host string) (string, error) {
+		if host == "error.com" {
+			return "", errors.New("server failure")
+		}
+		if target, f := aliases[host]; f {
+			return target, nil
+		}
+		return host + ".", nil
+	}
+	var resolved string
+	delegate := func(_ context.Context, host string) ([]netip.Addr, error) {
+		resolved = host
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	lookup := NewCNAMEFlatteningLookup(cname, delegate)
+
+	tests := []struct {
+		name     string
+		host     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "chain is followed", host: "www.foo.com", expected: "edge.cdn.net"},
+		{name: "no alias", host: "plain.com", expected: "plain.com"},
+		{name: "empty target ends the chain", host: "broken.com", expected: "broken.com"},
+		{name: "self alias ends the chain", host: "self.foo.com", expected: "self.foo.com"},
+		{name: "loop", host: "loop-a.com", wantErr: true},
+		{name: "overlong chain", host: "chain0.com", wantErr: true},
+		{name: "cname error", host: "error.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		resolved = ""
+		_, err := lookup(context.Background(), tt.host)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if resolved != tt.expected {
+			t.Errorf("Test %s failed, expected delegate to resolve %q, got %q", tt.name, tt.expected, resolved)
+		}
+	}
+}
+
+func TestNewAuditingLookup(t *testing.T) {
+	var entries []AuditEntry
+	failing := errors.New("lookup failed")
+	delegate := func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host == "bad.com" {
+			return nil, failing
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	lookup := NewAuditingLookup(delegate, func(e AuditEntry) {
+		entries = append(entries, e)
+	})
+	before := time.Now()
+	_, _ = lookup(context.Background(), "www.foo.com")
+	_, _ = lookup(context.Background(), "bad.com")
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].Host != "www.foo.com" || !reflect.DeepEqual(entries[0].Results, []netip.Addr{netip.MustParseAddr("1.2.3.4")}) ||
+		entries[0].Err != nil {
+		t.Errorf("unexpected audit entry %+v", entries[0])
+	}
+	if entries[1].Host != "bad.com" || entries[1].Results != nil || entries[1].Err != failing {
+		t.Errorf("unexpected audit entry %+v", entries[1])
+	}
+	for _, e := range entries {
+		if e.At.Before(before) {
+			t.Errorf("expected audit time after %v, got %v", before, e.At)
+		}
+	}
+
+	if result, err := NewAuditingLookup(delegate, nil)(context.Background(), "www.foo.com"); err != nil || len(result) != 1 {
+		t.Errorf("expected nil sink to pass through, got %v, %v", result, err)
+	}
+}
+
+func TestNewStickyLookup(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	answers := [][]netip.Addr{
+		{netip.MustParseAddr("1.2.3.4")},
+		{netip.MustParseAddr("1.2.3.5")},
+		{netip.MustParseAddr("1.2.3.6")},
+	}
+	calls := map[string]int{}
+	delegate := func(_ context.Context, host string) ([]netip.Addr, error) {
+		defer func() { calls[host]++ }()
+		return answers[calls[host]%len(answers)], nil
+	}
+	hold := func(host string) time.Duration {
+		if host == "short.com" {
+			return time.Second
+		}
+		return time.Minute
+	}
+	lookup := newStickyLookup(delegate, hold, clock.Now)
+
+	expect := func(host string, want netip.Addr) {
+		t.Helper()
+		got, err := lookup(context.Background(), host)
+		if err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+		if !reflect.DeepEqual(got, []netip.Addr{want}) {
+			t.Errorf("lookup of %s expected: %v got: %v", host, want, got)
+		}
+	}
+
+	expect("www.foo.com", answers[0][0])
+	expect("short.com", answers[0][0])
+	clock.t = clock.t.Add(30 * time.Second)
+	// www.foo.com is still held, short.com has expired and is re-evaluated.
+	expect("www.foo.com", answers[0][0])
+	expect("short.com", answers[1][0])
+	clock.t = clock.t.Add(30 * time.Second)
+	expect("www.foo.com", answers[1][0])
+
+	if calls["www.foo.com"] != 2 || calls["short.com"] != 2 {
+		t.Errorf("unexpected delegate calls %v", calls)
+	}
+}
+
+func TestNewRotatingLookup(t *testing.T) {
+	lookup := NewRotatingLookup(staticLookup("1.2.3.4", "1.2.3.5", "1.2.3.6"))
+	expected := [][]string{
+		{"1.2.3.4", "1.2.3.5", "1.2.3.6"},
+		{"1.2.3.5", "1.2.3.6", "1.2.3.4"},
+		{"1.2.3.6", "1.2.3.4", "1.2.3.5"},
+		{"1.2.3.4", "1.2.3.5", "1.2.3.6"},
+	}
+	for i, want := range expected {
+		got, err := lookup(context.Background(), "www.foo.com")
+		if err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+		if result := addrsToStrings(got); !reflect.DeepEqual(result, want) {
+			t.Errorf("call %d expected: %v got: %v", i, want, result)
+		}
+	}
+	// Rotation state is tracked per host.
+	got, _ := lookup(context.Background(), "www.bar.com")
+	if result := addrsToStrings(got); !reflect.DeepEqual(result, expected[0]) {
+		t.Errorf("expected: %v got: %v", expected[0], result)
+	}
+}
+
+func TestNewRotatingLookupConcurrent(t *testing.T) {
+	lookup := NewRotatingLookup(staticLookup("1.2.3.4", "1.2.3.5"))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				got, err := lookup(context.Background(), "www.foo.com")
+				if err != nil || len(got) != 2 {
+					t.Errorf("unexpected result %v, %v", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewMDNSAwareLookup(t *testing.T) {
+	mdnsAddr := netip.MustParseAddr("192.168.1.10")
+	dnsAddr := netip.MustParseAddr("1.2.3.4")
+	mdns := func(string) ([]netip.Addr, error) {
+		return []netip.Addr{mdnsAddr}, nil
+	}
+	lookup := NewMDNSAwareLookup(mdns, staticLookup(dnsAddr.String()))
+
+	tests := []struct {
+		host     string
+		expected netip.Addr
+	}{
+		{host: "printer.local", expected: mdnsAddr},
+		{host: "Printer.LOCAL", expected: mdnsAddr},
+		{host: "printer.local.", expected: mdnsAddr},
+		{host: "www.foo.com", expected: dnsAddr},
+		{host: "local", expected: dnsAddr},
+		{host: "printer.localdomain", expected: dnsAddr},
+	}
+	for _, tt := range tests {
+		result, err := lookup(context.Background(), tt.host)
+		if err != nil {
+			t.Fatalf("Test %s failed, expected success, got: %v", tt.host, err)
+		}
+		if !reflect.DeepEqual(result, []netip.Addr{tt.expected}) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.host, tt.expected, result)
+		}
+	}
+}
+
+func TestNewMaxTTLLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		maxTTL   time.Duration
+		expected time.Duration
+	}{
+		{name: "below max", ttl: 30 * time.Second, maxTTL: time.Minute, expected: 30 * time.Second},
+		{name: "at max", ttl: time.Minute, maxTTL: time.Minute, expected: time.Minute},
+		{name: "just above max", ttl: time.Minute + time.Nanosecond, maxTTL: time.Minute, expected: time.Minute},
+		{name: "far above max", ttl: 24 * time.Hour, maxTTL: time.Minute, expected: time.Minute},
+		{name: "zero ttl", ttl: 0, maxTTL: time.Minute, expected: 0},
+		{name: "negative ttl", ttl: -time.Second, maxTTL: time.Minute, expected: 0},
+		{name: "zero max", ttl: time.Minute, maxTTL: 0, expected: 0},
+		{name: "negative max", ttl: time.Minute, maxTTL: -time.Second, expected: 0},
+	}
+	for _, tt := range tests {
+		delegate := func(_ context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+			return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, tt.ttl, nil
+		}
+		addrs, ttl, err := NewMaxTTLLookup(delegate, tt.maxTTL)(context.Background(), "www.foo.com")
+		if err != nil || len(addrs) != 1 {
+			t.Fatalf("Test %s failed, expected success, got: %v, %v", tt.name, addrs, err)
+		}
+		if ttl != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, ttl)
+		}
+	}
+
+	failing := func(_ context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+		return nil, time.Hour, errors.New("lookup failed")
+	}
+	if _, _, err := NewMaxTTLLookup(failing, time.Minute)(context.Background(), "www.foo.com"); err == nil {
+		t.Errorf("expected delegate error to be returned")
+	}
+}
+
+func TestNewCIDRFilterLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    []string
+		addrs    []string
+		expected []string
+		err      error
+	}{
+		{
+			name:     "filters disallowed addresses",
+			allow:    []string{"203.0.113.0/24", "2001:db8::/32"},
+			addrs:    []string{"203.0.113.5", "10.0.0.1", "2001:db8::1", "fe80::1", "::ffff:203.0.113.6"},
+			expected: []string{"203.0.113.5", "2001:db8::1", "::ffff:203.0.113.6"},
+		},
+		{
+			name:     "mapped allow prefix",
+			allow:    []string{"::ffff:203.0.113.0/120"},
+			addrs:    []string{"203.0.113.5", "10.0.0.1"},
+			expected: []string{"203.0.113.5"},
+		},
+		{
+			name:  "everything filtered",
+			allow: []string{"203.0.113.0/24"},
+			addrs: []string{"127.0.0.1", "10.0.0.1"},
+			err:   ErrResolveNoAddress,
+		},
+		{
+			name:  "no allow list",
+			allow: nil,
+			addrs: []string{"203.0.113.5"},
+			err:   ErrResolveNoAddress,
+		},
+	}
+	for _, tt := range tests {
+		lookup, err := NewCIDRFilterLookup(staticLookup(tt.addrs...), tt.allow)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", tt.name, err)
+		}
+		got, err := lookup(context.Background(), "www.foo.com")
+		if err != tt.err {
+			t.Errorf("[%s] expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if tt.err == nil && !reflect.DeepEqual(addrsToStrings(got), tt.expected) {
+			t.Errorf("[%s] expected: %v got: %v", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, err := NewCIDRFilterLookup(staticLookup(), []string{"10.0.0.0/8", "invalid"}); err == nil {
+		t.Errorf("expected error for malformed allow list")
+	}
+}
+
+func TestNewLocalFamilyPreferringLookup(t *testing.T) {
+	addrs := []string{"1.2.3.4", "2001:db8::1", "::ffff:1.2.3.5", "2001:db8::2"}
+	tests := []struct {
+		family   IPFamily
+		expected []string
+	}{
+		{family: V4, expected: []string{"1.2.3.4", "::ffff:1.2.3.5", "2001:db8::1", "2001:db8::2"}},
+		{family: V6, expected: []string{"2001:db8::1", "2001:db8::2", "1.2.3.4", "::ffff:1.2.3.5"}},
+		{family: Mixed, expected: addrs},
+		{family: Unknown, expected: addrs},
+	}
+	for _, tt := range tests {
+		lookup := NewLocalFamilyPreferringLookup(staticLookup(addrs...), tt.family)
+		got, err := lookup(context.Background(), "www.foo.com")
+		if err != nil {
+			t.Fatalf("expected success, but saw error: %v", err)
+		}
+		if result := addrsToStrings(got); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.family, tt.expected, result)
+		}
+	}
+}
+
+type fakeTracer struct {
+	spans []string
+}
+
+func (f *fakeTracer) StartSpan(name string) func(err error) {
+	return func(err error) {
+		result := "ok"
+		if err != nil {
+			result = err.Error()
+		}
+		f.spans = append(f.spans, name+": "+result)
+	}
+}
+
+func TestNewTracedLookup(t *testing.T) {
+	tracer := &fakeTracer{}
+	delegate := func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host == "www.bar.com" {
+			return nil, errors.New("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+	lookup := NewTracedLookup(delegate, tracer)
+	if addrs, err := lookup(context.Background(), "www.foo.com"); err != nil || len(addrs) != 1 {
+		t.Errorf("expected success, got %v %v", addrs, err)
+	}
+	if _, err := lookup(context.Background(), "www.bar.com"); err == nil {
+		t.Errorf("expected lookup error to be returned")
+	}
+	expected := []string{"www.foo.com: ok", "www.bar.com: no such host"}
+	if !reflect.DeepEqual(tracer.spans, expected) {
+		t.Errorf("expected spans %v, got %v", expected, tracer.spans)
+	}
+
+	if addrs, err := NewTracedLookup(delegate, nil)(context.Background(), "www.foo.com"); err != nil || len(addrs) != 1 {
+		t.Errorf("expected untraced lookup to succeed, got %v %v", addrs, err)
+	}
+}
+
+// waitingContext reports on waiting when Done is first called, which the
+// singleflight lookup only does once it has joined the in-flight lookup.
+type waitingContext struct {
+	context.Context
+	once    sync.Once
+	waiting chan<- struct{}
+}
+
+func (c *waitingContext) Done() <-chan struct{} {
+	c.once.Do(func() { c.waiting <- struct{}{} })
+	return c.Context.Done()
+}
+
+func TestNewSingleflightLookup(t *testing.T) {
+	const callers = 10
+	var calls int32
+	release := make(chan struct{})
+	delegate := func(_ context.Context, host string) ([]netip.Addr, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if host == "error.singleflight.test" {
+			return nil, errors.New("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+
+	for _, host := range []string{"ok.singleflight.test", "error.singleflight.test"} {
+		atomic.StoreInt32(&calls, 0)
+		release = make(chan struct{})
+		lookup := NewSingleflightLookup(delegate)
+		waiting := make(chan struct{})
+		wg := sync.WaitGroup{}
+		errs := make([]error, callers)
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = lookup(&waitingContext{Context: context.Background(), waiting: waiting}, host)
+			}(i)
+		}
+		for i := 0; i < callers; i++ {
+			<-waiting
+		}
+		close(release)
+		wg.Wait()
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("%s: expected 1 delegate call, got %d", host, n)
+		}
+		for _, err := range errs {
+			if wantErr := host == "error.singleflight.test"; (err != nil) != wantErr {
+				t.Errorf("%s: expected error: %t got: %v", host, wantErr, err)
+			}
+		}
+	}
+}
+
+func TestNewSingleflightLookupSeparateWrappers(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	blocked := NewSingleflightLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		close(started)
+		<-release
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	})
+	other := NewSingleflightLookup(staticLookup("5.6.7.8"))
+
+	result := make(chan []netip.Addr)
+	go func() {
+		addrs, _ := blocked(context.Background(), "www.foo.com")
+		result <- addrs
+	}()
+	<-started
+	// A lookup of the same host through another wrapper must not join the one in flight.
+	addrs, err := other(context.Background(), "www.foo.com")
+	if expected := []netip.Addr{netip.MustParseAddr("5.6.7.8")}; err != nil || !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("expected %v from the other wrapper, got %v %v", expected, addrs, err)
+	}
+	close(release)
+	if addrs, expected := <-result, []netip.Addr{netip.MustParseAddr("1.2.3.4")}; !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("expected %v from the blocked wrapper, got %v", expected, addrs)
+	}
+}
+
+func TestNewGlobalSingleflightLookup(t *testing.T) {
+	const callers = 10
+	var calls int32
+	release := make(chan struct{})
+	delegate := func(_ context.Context, _ string) ([]netip.Addr, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	}
+
+	waiting := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		// Separate wrappers of the same delegate share in-flight lookups.
+		lookup := NewGlobalSingleflightLookup(delegate)
+		go func() {
+			defer wg.Done()
+			if _, err := lookup(&waitingContext{Context: context.Background(), waiting: waiting}, "www.foo.com"); err != nil {
+				t.Errorf("expected success, but saw error: %v", err)
+			}
+		}()
+	}
+	for i := 0; i < callers; i++ {
+		<-waiting
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 delegate call, got %d", n)
+	}
+}
+
+func TestNewGlobalSingleflightLookupSeparateDelegates(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	blocked := NewGlobalSingleflightLookup(func(_ context.Context, _ string) ([]netip.Addr, error) {
+		close(started)
+		<-release
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	})
+	// Closures of the same function must not share results either.
+	filtered := NewGlobalSingleflightLookup(staticLookup("5.6.7.8"))
+	unfiltered := NewGlobalSingleflightLookup(staticLookup("9.9.9.9"))
+
+	result := make(chan []netip.Addr)
+	go func() {
+		addrs, _ := blocked(context.Background(), "www.foo.com")
+		result <- addrs
+	}()
+	<-started
+	for _, tt := range []struct {
+		lookup LookupIPAddrType
+		want   string
+	}{{filtered, "5.6.7.8"}, {unfiltered, "9.9.9.9"}} {
+		addrs, err := tt.lookup(context.Background(), "www.foo.com")
+		if expected := []netip.Addr{netip.MustParseAddr(tt.want)}; err != nil || !reflect.DeepEqual(addrs, expected) {
+			t.Errorf("expected %v from a separate delegate, got %v %v", expected, addrs, err)
+		}
+	}
+	close(release)
+	if addrs, expected := <-result, []netip.Addr{netip.MustParseAddr("1.2.3.4")}; !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("expected %v from the blocked delegate, got %v", expected, addrs)
+	}
+}
+
+func TestNewSingleflightLookupCancel(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var startOnce sync.Once
+	var delegateErr error
+	lookup := NewSingleflightLookup(func(ctx context.Context, _ string) ([]netip.Addr, error) {
+		startOnce.Do(func() { close(started) })
+		<-release
+		delegateErr = ctx.Err()
+		return []netip.Addr{netip.MustParseAddr("1.2.3.4")}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := lookup(ctx, "cancel.singleflight.test")
+		cancelled <- err
+	}()
+	<-started
+	waiting := make(chan struct{})
+	result := make(chan []netip.Addr)
+	go func() {
+		addrs, _ := lookup(&waitingContext{Context: context.Background(), waiting: waiting}, "cancel.singleflight.test")
+		result <- addrs
+	}()
+	<-waiting
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("expected cancelled waiter to return %v, got %v", context.Canceled, err)
+	}
+	close(release)
+	if addrs := <-result; len(addrs) != 1 {
+		t.Errorf("expected remaining waiter to get the result, got %v", addrs)
+	}
+	if delegateErr != nil {
+		t.Errorf("expected the shared lookup not to be cancelled, got %v", delegateErr)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortKind is the IANA classification of a port number, as returned by PortClass.
+type PortKind int
+
+const (
+	_ PortKind = iota
+	// WellKnown ports are in the range 0-1023.
+	WellKnown
+	// Registered ports are in the range 1024-49151.
+	Registered
+	// Ephemeral ports are in the range 49152-65535.
+	Ephemeral
+)
+
+func (k PortKind) String() string {
+	switch k {
+	case WellKnown:
+		return "WellKnown"
+	case Registered:
+		return "Registered"
+	case Ephemeral:
+		return "Ephemeral"
+	default:
+		return "Unknown"
+	}
+}
+
+// PortClass classifies port as a well-known, registered or ephemeral port.
+// An error is returned if port is not a number in the range 0-65535.
+func PortClass(port string) (PortKind, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+	switch {
+	case p <= 1023:
+		return WellKnown, nil
+	case p <= 49151:
+		return Registered, nil
+	default:
+		return Ephemeral, nil
+	}
+}
+
+// ParsePortRange parses a port range such as 8000-8100. A single port such as
+// 8080 is parsed as a range whose start and end are equal. Both ends must be
+// in the range 1-65535 and start must not be greater than end.
+func ParsePortRange(s string) (start, end uint16, err error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if start, err = parseRangePort(first); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	if end, err = parseRangePort(last); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q: start %d is greater than end %d", s, start, end)
+	}
+	return start, end, nil
+}
+
+func parseRangePort(s string) (uint16, error) {
+	p, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || p == 0 {
+		return 0, fmt.Errorf("port %q must be in the range 1-65535", s)
+	}
+	return uint16(p), nil
+}
@@ -0,0 +1,80 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"testing"
+)
+
+func TestPortClass(t *testing.T) {
+	tests := []struct {
+		port     string
+		expected PortKind
+		wantErr  bool
+	}{
+		{port: "0", expected: WellKnown},
+		{port: "80", expected: WellKnown},
+		{port: "1023", expected: WellKnown},
+		{port: "1024", expected: Registered},
+		{port: "49151", expected: Registered},
+		{port: "49152", expected: Ephemeral},
+		{port: "65535", expected: Ephemeral},
+		{port: "65536", wantErr: true},
+		{port: "-1", wantErr: true},
+		{port: "http", wantErr: true},
+		{port: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := PortClass(tt.port)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %q failed, expected error: %t got: %v", tt.port, tt.wantErr, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Test %q failed, expected: %v got: %v", tt.port, tt.expected, result)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		start   uint16
+		end     uint16
+		wantErr bool
+	}{
+		{input: "8000-8100", start: 8000, end: 8100},
+		{input: "8080", start: 8080, end: 8080},
+		{input: "8080-8080", start: 8080, end: 8080},
+		{input: "1-65535", start: 1, end: 65535},
+		{input: "8100-8000", wantErr: true},
+		{input: "0-80", wantErr: true},
+		{input: "80-65536", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "8000-", wantErr: true},
+		{input: "-8000", wantErr: true},
+		{input: "8000-8100-8200", wantErr: true},
+		{input: "http", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParsePortRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %q failed, expected error: %t got: %v", tt.input, tt.wantErr, err)
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("Test %q failed, expected: %d-%d got: %d-%d", tt.input, tt.start, tt.end, start, end)
+		}
+	}
+}
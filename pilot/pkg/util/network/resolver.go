@@ -0,0 +1,213 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/exp/slices"
+
+	"istio.io/pkg/log"
+)
+
+// Resolver resolves addresses using a lookup function that can be swapped at
+// runtime, e.g. to switch between the system resolver and a custom DNS server.
+// The zero value uses net.DefaultResolver. It is safe for concurrent use.
+type Resolver struct {
+	lookup atomic.Pointer[LookupIPAddrType]
+}
+
+// NewResolver creates a Resolver using lookup; a nil lookup means net.DefaultResolver.
+func NewResolver(lookup LookupIPAddrType) *Resolver {
+	r := &Resolver{}
+	r.SetLookup(lookup)
+	return r
+}
+
+// SetLookup replaces the lookup function used by subsequent calls to Resolve.
+// A nil lookup restores the use of net.DefaultResolver.
+func (r *Resolver) SetLookup(lookup LookupIPAddrType) {
+	if lookup == nil {
+		r.lookup.Store(nil)
+		return
+	}
+	r.lookup.Store(&lookup)
+}
+
+// Resolve resolves addr as ResolveAddr does, using the current lookup function.
+func (r *Resolver) Resolve(addr string) (string, error) {
+	if lookup := r.lookup.Load(); lookup != nil {
+		return ResolveAddr(addr, *lookup)
+	}
+	return ResolveAddr(addr)
+}
+
+// newCustomResolver returns a resolver that sends all queries to server, a host:port address.
+func newCustomResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// MeasureResolverLatency returns how long the DNS server at resolver takes to
+// answer a query for sample. resolver is a host or host:port address; port 53
+// is used if none is given. IPv6 hosts without a port may be bracketed, e.g.
+// [::1]. The lookup error is returned if the server fails to answer within
+// timeout.
+func MeasureResolverLatency(ctx context.Context, resolver string, sample string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	if _, err := newCustomResolver(resolverAddress(resolver)).LookupNetIP(ctx, "ip", sample); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// resolverAddress returns resolver as a host:port address, adding the default
+// DNS port 53 if resolver has no port.
+func resolverAddress(resolver string) string {
+	if _, _, err := net.SplitHostPort(resolver); err == nil {
+		return resolver
+	}
+	if strings.HasPrefix(resolver, "[") && strings.HasSuffix(resolver, "]") {
+		resolver = resolver[1 : len(resolver)-1]
+	}
+	return net.JoinHostPort(resolver, "53")
+}
+
+// ResolveNames resolves all names concurrently and returns the sorted,
+// deduplicated union of their addresses, e.g. for a service published as
+// svc, svc.default and svc.default.svc. It succeeds if at least one name
+// resolves; otherwise the per-name errors are returned.
+func ResolveNames(ctx context.Context, names []string, lookup LookupIPAddrType) ([]string, error) {
+	if len(names) == 0 {
+		return nil, ErrResolveNoAddress
+	}
+	results := make([][]netip.Addr, len(names))
+	errs := make([]error, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i], errs[i] = lookupHost(ctx, name, lookup)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var merr *multierror.Error
+	set := map[netip.Addr]struct{}{}
+	for i, name := range names {
+		if errs[i] != nil {
+			merr = multierror.Append(merr, fmt.Errorf("lookup of %s failed: %w", name, errs[i]))
+			continue
+		}
+		for _, a := range results[i] {
+			if a.IsValid() {
+				set[a.Unmap()] = struct{}{}
+			}
+		}
+	}
+	if len(set) == 0 {
+		if err := merr.ErrorOrNil(); err != nil {
+			return nil, err
+		}
+		return nil, ErrResolveNoAddress
+	}
+	addrs := make([]netip.Addr, 0, len(set))
+	for a := range set {
+		addrs = append(addrs, a)
+	}
+	return addrsToStrings(sortedAddrs(addrs)), nil
+}
+
+// WatchResolve resolves addr, a host:port address, every interval and sends
+// the sorted resolved endpoints on the returned channel: immediately after the
+// first successful resolution, and then whenever the set changes. Failed
+// resolutions are logged and the previous result is kept. The channel is
+// closed when ctx is done, or immediately if addr is malformed or interval is
+// not positive. It suits select-loop consumers reacting to DNS changes.
+func WatchResolve(ctx context.Context, addr string, interval time.Duration, lookup LookupIPAddrType) <-chan []string {
+	ch := make(chan []string)
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		log.Warnf("not watching %s: invalid address: %v", addr, err)
+		close(ch)
+		return ch
+	}
+	if interval <= 0 {
+		log.Warnf("not watching %s: interval must be positive, got %v", addr, interval)
+		close(ch)
+		return ch
+	}
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last []string
+		for {
+			current, err := resolveEndpointStrings(ctx, host, port, lookup)
+			if err != nil {
+				log.Warnf("failed to resolve watched address %s: %v", addr, err)
+			} else if last == nil || !slices.Equal(last, current) {
+				select {
+				case ch <- current:
+					last = current
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// resolveEndpointStrings returns the sorted endpoints for host and port.
+func resolveEndpointStrings(ctx context.Context, host string, port uint16, lookup LookupIPAddrType) ([]string, error) {
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else if addrs, err = lookupHost(ctx, host, lookup); err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, 0, len(addrs))
+	for _, a := range sortedAddrs(unmapAddrs(addrs)) {
+		if a.IsValid() {
+			endpoints = append(endpoints, netip.AddrPortFrom(a, port).String())
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrResolveNoAddress
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,276 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolverSetLookup(t *testing.T) {
+	r := NewResolver(staticLookup("1.2.3.4"))
+	if got, err := r.Resolve("www.foo.com:9080"); err != nil || got != "1.2.3.4:9080" {
+		t.Fatalf("expected 1.2.3.4:9080, got %q, %v", got, err)
+	}
+	r.SetLookup(staticLookup("1.2.3.5"))
+	if got, err := r.Resolve("www.foo.com:9080"); err != nil || got != "1.2.3.5:9080" {
+		t.Fatalf("expected 1.2.3.5:9080, got %q, %v", got, err)
+	}
+}
+
+func TestResolverConcurrentSwap(t *testing.T) {
+	lookups := []LookupIPAddrType{staticLookup("1.2.3.4"), staticLookup("1.2.3.5")}
+	r := NewResolver(lookups[0])
+
+	stop := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				r.SetLookup(lookups[i%2])
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				got, err := r.Resolve("www.foo.com:9080")
+				if err != nil {
+					t.Errorf("expected success, but saw error: %v", err)
+					return
+				}
+				if got != "1.2.3.4:9080" && got != "1.2.3.5:9080" {
+					t.Errorf("unexpected address %q", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-swapped
+}
+
+// startTestDNSServer starts a UDP DNS server answering A queries for the given
+// names and returns its address.
+func startTestDNSServer(t *testing.T, records map[string]string) string {
+	t.Helper()
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(resp dns.ResponseWriter, msg *dns.Msg) {
+		answer := &dns.Msg{}
+		answer.SetReply(msg)
+		q := msg.Question[0]
+		if ip, f := records[q.Name]; f && q.Qtype == dns.TypeA {
+			answer.Answer = append(answer.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+		_ = resp.WriteMsg(answer)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	up := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: mux, NotifyStartedFunc: func() { close(up) }}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	select {
+	case <-time.After(time.Second * 10):
+		t.Fatalf("setup timeout")
+	case <-up:
+	}
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestMeasureResolverLatency(t *testing.T) {
+	addr := startTestDNSServer(t, map[string]string{"www.foo.com.": "1.2.3.4"})
+
+	latency, err := MeasureResolverLatency(context.Background(), addr, "www.foo.com", 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected success, but saw error: %v", err)
+	}
+	if latency <= 0 {
+		t.Errorf("expected a positive latency, got %v", latency)
+	}
+
+	if _, err := MeasureResolverLatency(context.Background(), addr, "www.bar.com", 5*time.Second); err == nil {
+		t.Errorf("expected error resolving unknown name")
+	}
+
+	// A server that never answers should time out.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer pc.Close()
+	if _, err := MeasureResolverLatency(context.Background(), pc.LocalAddr().String(), "www.foo.com", 100*time.Millisecond); err == nil {
+		t.Errorf("expected error from unresponsive resolver")
+	}
+}
+
+func TestResolverAddress(t *testing.T) {
+	tests := []struct {
+		resolver string
+		expected string
+	}{
+		{resolver: "10.0.0.10", expected: "10.0.0.10:53"},
+		{resolver: "10.0.0.10:5353", expected: "10.0.0.10:5353"},
+		{resolver: "::1", expected: "[::1]:53"},
+		{resolver: "[::1]", expected: "[::1]:53"},
+		{resolver: "[::1]:5353", expected: "[::1]:5353"},
+		{resolver: "kube-dns.kube-system", expected: "kube-dns.kube-system:53"},
+	}
+	for _, tt := range tests {
+		if result := resolverAddress(tt.resolver); result != tt.expected {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.resolver, tt.expected, result)
+		}
+	}
+}
+
+func TestResolveNames(t *testing.T) {
+	lookup := func(_ context.Context, host string) ([]netip.Addr, error) {
+		switch host {
+		case "svc":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")}, nil
+		case "svc.default":
+			return []netip.Addr{netip.MustParseAddr("::ffff:10.0.0.1"), netip.MustParseAddr("2001:db8::1")}, nil
+		case "svc.default.svc":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.3")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	tests := []struct {
+		name     string
+		names    []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "union of all names",
+			names:    []string{"svc", "svc.default", "svc.default.svc"},
+			expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "2001:db8::1"},
+		},
+		{
+			name:     "partial failure",
+			names:    []string{"svc.default.svc", "missing"},
+			expected: []string{"10.0.0.3"},
+		},
+		{
+			name:    "all names fail",
+			names:   []string{"missing", "missing.default"},
+			wantErr: true,
+		},
+		{
+			name:    "no names",
+			names:   nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		result, err := ResolveNames(context.Background(), tt.names, lookup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Test %s failed, expected error: %t got: %v", tt.name, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Test %s failed, expected: %v got: %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestWatchResolve(t *testing.T) {
+	var mu sync.Mutex
+	current := []netip.Addr{netip.MustParseAddr("1.2.3.5"), netip.MustParseAddr("1.2.3.4")}
+	var lookupErr error
+	lookup := func(context.Context, string) ([]netip.Addr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return current, lookupErr
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := WatchResolve(ctx, "www.foo.com:80", time.Millisecond, lookup)
+
+	expect := func(want []string) {
+		t.Helper()
+		select {
+		case got, ok := <-ch:
+			if !ok || !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %v, got %v (open: %t)", want, got, ok)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+	expect([]string{"1.2.3.4:80", "1.2.3.5:80"})
+
+	// Failures keep the previous result.
+	mu.Lock()
+	lookupErr = errors.New("server failure")
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	current, lookupErr = []netip.Addr{netip.MustParseAddr("2001:db8::1")}, nil
+	mu.Unlock()
+	expect([]string{"[2001:db8::1]:80"})
+
+	// Unchanged results are not sent again.
+	select {
+	case got := <-ch:
+		t.Fatalf("expected no update for an unchanged result, got %v", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("expected channel to be closed after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for channel to close")
+	}
+}
+
+func TestWatchResolveInvalid(t *testing.T) {
+	for _, addr := range []string{"", "www.foo.com"} {
+		if _, ok := <-WatchResolve(context.Background(), addr, time.Second, nil); ok {
+			t.Errorf("expected channel for %q to be closed", addr)
+		}
+	}
+	if _, ok := <-WatchResolve(context.Background(), "1.2.3.4:80", 0, nil); ok {
+		t.Errorf("expected channel for zero interval to be closed")
+	}
+}